@@ -1,7 +1,12 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net/http"
+	"os/signal"
+	"syscall"
 
 	"github.com/tonespy/ecosort_be/config"
 	"github.com/tonespy/ecosort_be/internal/server"
@@ -29,8 +34,27 @@ func main() {
 	}
 
 	router := server.NewRouter()
-	err = router.Run(app_config.Port)
-	if err != nil {
-		log.Fatal(err)
+	httpServer := &http.Server{
+		Addr:    app_config.Port,
+		Handler: router,
+	}
+
+	go func() {
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	// Wait for a termination signal
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	<-ctx.Done()
+	stop()
+
+	appLogger.Info("Shutting down server", map[string]interface{}{"timeout": app_config.ShutdownTimeout.String()})
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), app_config.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx, httpServer); err != nil {
+		appLogger.Error("Graceful shutdown incomplete", map[string]interface{}{"error": err.Error()}, err)
 	}
+	appLogger.Info("Server stopped", nil)
 }
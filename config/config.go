@@ -9,7 +9,9 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -42,16 +44,61 @@ type ModelInfo struct {
 	Accuracy        string `json:"accuracy"`
 }
 
+// QualityConfig holds the image quality gate's mode ("off", "warn" or
+// "reject") and the thresholds images are checked against before inference.
+type QualityConfig struct {
+	Mode          string
+	MinSide       int     // Pixels on the shorter side
+	MinSharpness  float64 // Variance of the Laplacian
+	MinBrightness float64 // Mean luminance, 0 to 255
+	MaxBrightness float64
+	MinContrast   float64 // Standard deviation of the luminance
+}
+
 type Config struct {
-	Port             string
-	GinMode          string
-	ModelPath        string
-	RootDir          string
-	SupportedClasses []Classes
-	ModelVersions    []ModelInfo
-	ModelAPIKey      string
-	APIKey           string
-	ModelGrouping    []GroupConfig
+	Port               string
+	GinMode            string
+	ModelPath          string
+	RootDir            string
+	SupportedClasses   []Classes
+	ModelVersions      []ModelInfo
+	ModelAPIKey        string
+	APIKeys            []string
+	ModelGrouping      []GroupConfig
+	ModelWarmupRuns    int
+	ShutdownTimeout    time.Duration
+	JobStoreType       string
+	JobStorePath       string
+	JobWorkers         int
+	JobQueueDepth      int
+	JobPriorityAging   time.Duration
+	JobRetention       time.Duration
+	TempFileMaxAge     time.Duration
+	JanitorInterval    time.Duration
+	ImageRetryAttempts int
+	ImageRetryBackoff  time.Duration
+	InferenceTimeout   time.Duration
+	WebhookSecret      string
+	WebhookMaxAttempts int
+	WebhookBackoff     time.Duration
+	WebhookTimeout     time.Duration
+	UploadMaxFiles     int
+	UploadMaxFileSize  int64
+	UploadMaxSize      int64
+	UploadSessionTTL   time.Duration
+	ArchiveMaxEntries  int
+	ArchiveMaxSize     int64
+	ImageURLAllowlist  []string
+	ImageURLMaxSize    int64
+	ImageURLTimeout    time.Duration
+	ImageURLJobTimeout time.Duration
+	PredictMemoryLimit int64
+	ResultCacheSize    int
+	ResultCacheTTL     time.Duration
+	DuplicateDistance  int
+	Quality            QualityConfig
+	DetectionGrid      int
+	DetectionThreshold float64
 }
 
 // GetBaseWorkingDirectory returns the base project directory
@@ -79,6 +126,21 @@ func getBaseWorkingDirectory() (string, error) {
 	return "", fmt.Errorf("could not find the project base directory")
 }
 
+// getEnvInt reads an integer environment variable, falling back to the
+// provided default when it is unset or malformed.
+func getEnvInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		fmt.Printf("Invalid value for %s: %q, using default %d\n", key, value, fallback)
+		return fallback
+	}
+	return parsed
+}
+
 // Convert bytes to MB: 59098816 bytes
 // 59098816 / 1024 / 1024 = ~56.36 MB
 // 59098816 / 1024 / 1024 / 1024 = ~0.055 GB
@@ -324,8 +386,14 @@ func PrepareConfig() (*Config, error) {
 		return nil, fmt.Errorf("MODEL_RELEASE_API_KEY is not set")
 	}
 
-	apiKey := os.Getenv("API_REQ_KEY")
-	if apiKey == "" {
+	// API_REQ_KEY holds one key per client, comma-separated
+	var apiKeys []string
+	for _, key := range strings.Split(os.Getenv("API_REQ_KEY"), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			apiKeys = append(apiKeys, key)
+		}
+	}
+	if len(apiKeys) == 0 {
 		return nil, fmt.Errorf("API_REQ_KEY is not set")
 	}
 
@@ -334,16 +402,150 @@ func PrepareConfig() (*Config, error) {
 		port = "5500"
 	}
 
+	// Number of synthetic inferences to run before reporting ready
+	warmupRuns := getEnvInt("MODEL_WARMUP_RUNS", 3)
+	if warmupRuns < 1 {
+		warmupRuns = 1
+	}
+
+	// Time allowed for in-flight jobs to finish on SIGTERM before they are stopped
+	shutdownTimeout := time.Duration(getEnvInt("SHUTDOWN_TIMEOUT_SECONDS", 30)) * time.Second
+
+	// Batch job persistence: "bolt" (embedded file, default) or "memory"
+	jobStoreType := os.Getenv("JOB_STORE")
+	if jobStoreType == "" {
+		jobStoreType = "bolt"
+	}
+	jobStorePath := os.Getenv("JOB_STORE_PATH")
+	if jobStorePath == "" {
+		jobStorePath = filepath.Join(rootDir, "tmp", "jobs.db")
+	}
+
+	// Batch job concurrency: workers processing jobs and the number of jobs allowed to wait
+	jobWorkers := max(getEnvInt("JOB_WORKERS", 2), 1)
+	jobQueueDepth := max(getEnvInt("JOB_QUEUE_DEPTH", 100), 1)
+	// Waiting time after which a queued job is promoted one priority level
+	jobPriorityAging := time.Duration(getEnvInt("JOB_PRIORITY_AGING_SECONDS", 120)) * time.Second
+
+	// Janitor: how long finished jobs are kept, when leftover upload directories
+	// count as orphaned, and how often the sweep runs
+	jobRetention := time.Duration(getEnvInt("JOB_RETENTION_HOURS", 24)) * time.Hour
+	tempFileMaxAge := time.Duration(getEnvInt("TEMP_FILE_MAX_AGE_MINUTES", 60)) * time.Minute
+	janitorInterval := time.Duration(max(getEnvInt("JANITOR_INTERVAL_MINUTES", 10), 1)) * time.Minute
+
+	// Batch image inference: attempts per image for transient errors, initial
+	// backoff between attempts (doubled each retry) and per-attempt timeout
+	imageRetryAttempts := max(getEnvInt("IMAGE_RETRY_ATTEMPTS", 3), 1)
+	imageRetryBackoff := time.Duration(getEnvInt("IMAGE_RETRY_BACKOFF_MS", 200)) * time.Millisecond
+	inferenceTimeout := time.Duration(max(getEnvInt("INFERENCE_TIMEOUT_SECONDS", 30), 1)) * time.Second
+
+	// Job callbacks are signed with WEBHOOK_SECRET; callback URLs are refused
+	// while it is unset
+	webhookSecret := os.Getenv("WEBHOOK_SECRET")
+	webhookMaxAttempts := max(getEnvInt("WEBHOOK_MAX_ATTEMPTS", 5), 1)
+	webhookBackoff := time.Duration(getEnvInt("WEBHOOK_BACKOFF_MS", 1000)) * time.Millisecond
+	webhookTimeout := time.Duration(max(getEnvInt("WEBHOOK_TIMEOUT_SECONDS", 10), 1)) * time.Second
+
+	// Batch uploads: files per request, bytes per file and bytes per request
+	uploadMaxFiles := max(getEnvInt("UPLOAD_MAX_FILES", 500), 1)
+	uploadMaxFileSize := int64(max(getEnvInt("UPLOAD_MAX_FILE_MB", 50), 1)) << 20
+	uploadMaxSize := int64(max(getEnvInt("UPLOAD_MAX_REQUEST_MB", 1024), 1)) << 20
+	// Resumable uploads without a chunk for this long are swept by the janitor
+	uploadSessionTTL := time.Duration(max(getEnvInt("UPLOAD_SESSION_TTL_HOURS", 24), 1)) * time.Hour
+	// Archive uploads: entries per archive and total uncompressed bytes, guarding against zip bombs
+	archiveMaxEntries := max(getEnvInt("ARCHIVE_MAX_ENTRIES", 10000), 1)
+	archiveMaxSize := int64(max(getEnvInt("ARCHIVE_MAX_UNCOMPRESSED_MB", 2048), 1)) << 20
+
+	// Images fetched by URL: hosts or CIDR ranges exempt from the private
+	// address block, size limit, time limit per fetch and for all of a job's fetches
+	var imageURLAllowlist []string
+	for _, entry := range strings.Split(os.Getenv("IMAGE_URL_ALLOWLIST"), ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			imageURLAllowlist = append(imageURLAllowlist, entry)
+		}
+	}
+	imageURLMaxSize := int64(max(getEnvInt("IMAGE_URL_MAX_MB", 20), 1)) << 20
+	imageURLTimeout := time.Duration(max(getEnvInt("IMAGE_URL_TIMEOUT_SECONDS", 15), 1)) * time.Second
+	imageURLJobTimeout := time.Duration(max(getEnvInt("IMAGE_URL_JOB_TIMEOUT_SECONDS", 300), 1)) * time.Second
+
+	// Single-image predictions are decoded in memory up to this size and
+	// spooled to a temporary file beyond it
+	predictMemoryLimit := int64(max(getEnvInt("PREDICT_MEMORY_MB", 8), 1)) << 20
+	// Predictions cached by image content hash; a size of 0 disables the cache
+	resultCacheSize := max(getEnvInt("RESULT_CACHE_SIZE", 1000), 0)
+	resultCacheTTL := time.Duration(max(getEnvInt("RESULT_CACHE_TTL_MINUTES", 60), 1)) * time.Minute
+	// Job images whose perceptual hashes differ in at most this many of 64
+	// bits are marked as near-duplicates
+	duplicateDistance := min(max(getEnvInt("DUPLICATE_MAX_DISTANCE", 6), 0), 64)
+
+	// Quality gate run on decoded images before inference: "warn" reports
+	// issues with the prediction, "reject" refuses the image
+	qualityMode := os.Getenv("QUALITY_MODE")
+	switch qualityMode {
+	case "":
+		qualityMode = "warn"
+	case "off", "warn", "reject":
+	default:
+		return nil, fmt.Errorf("unknown QUALITY_MODE: %s", qualityMode)
+	}
+	// Detection mode classifies a grid of tiles, keeping tiles classified
+	// with at least the threshold's confidence, in percent
+	detectionGrid := min(max(getEnvInt("DETECTION_GRID", 3), 1), 8)
+	detectionThreshold := float64(min(max(getEnvInt("DETECTION_MIN_CONFIDENCE", 50), 0), 100)) / 100
+
+	quality := QualityConfig{
+		Mode:          qualityMode,
+		MinSide:       getEnvInt("QUALITY_MIN_SIDE", 64),
+		MinSharpness:  float64(getEnvInt("QUALITY_MIN_SHARPNESS", 50)),
+		MinBrightness: float64(getEnvInt("QUALITY_MIN_BRIGHTNESS", 40)),
+		MaxBrightness: float64(getEnvInt("QUALITY_MAX_BRIGHTNESS", 225)),
+		MinContrast:   float64(getEnvInt("QUALITY_MIN_CONTRAST", 15)),
+	}
+
 	return &Config{
-		Port:             ":" + port,
-		GinMode:          ginMode,
-		ModelPath:        modelPath,
-		RootDir:          rootDir,
-		SupportedClasses: supportedClasses,
-		ModelVersions:    versions,
-		ModelAPIKey:      modelAPIKey,
-		APIKey:           apiKey,
-		ModelGrouping:    availableGroups,
+		Port:               ":" + port,
+		GinMode:            ginMode,
+		ModelPath:          modelPath,
+		RootDir:            rootDir,
+		SupportedClasses:   supportedClasses,
+		ModelVersions:      versions,
+		ModelAPIKey:        modelAPIKey,
+		APIKeys:            apiKeys,
+		ModelGrouping:      availableGroups,
+		ModelWarmupRuns:    warmupRuns,
+		ShutdownTimeout:    shutdownTimeout,
+		JobStoreType:       jobStoreType,
+		JobStorePath:       jobStorePath,
+		JobWorkers:         jobWorkers,
+		JobQueueDepth:      jobQueueDepth,
+		JobPriorityAging:   jobPriorityAging,
+		JobRetention:       jobRetention,
+		TempFileMaxAge:     tempFileMaxAge,
+		JanitorInterval:    janitorInterval,
+		ImageRetryAttempts: imageRetryAttempts,
+		ImageRetryBackoff:  imageRetryBackoff,
+		InferenceTimeout:   inferenceTimeout,
+		WebhookSecret:      webhookSecret,
+		WebhookMaxAttempts: webhookMaxAttempts,
+		WebhookBackoff:     webhookBackoff,
+		WebhookTimeout:     webhookTimeout,
+		UploadMaxFiles:     uploadMaxFiles,
+		UploadMaxFileSize:  uploadMaxFileSize,
+		UploadMaxSize:      uploadMaxSize,
+		UploadSessionTTL:   uploadSessionTTL,
+		ArchiveMaxEntries:  archiveMaxEntries,
+		ArchiveMaxSize:     archiveMaxSize,
+		ImageURLAllowlist:  imageURLAllowlist,
+		ImageURLMaxSize:    imageURLMaxSize,
+		ImageURLTimeout:    imageURLTimeout,
+		ImageURLJobTimeout: imageURLJobTimeout,
+		PredictMemoryLimit: predictMemoryLimit,
+		ResultCacheSize:    resultCacheSize,
+		ResultCacheTTL:     resultCacheTTL,
+		DuplicateDistance:  duplicateDistance,
+		Quality:            quality,
+		DetectionGrid:      detectionGrid,
+		DetectionThreshold: detectionThreshold,
 	}, nil
 }
 
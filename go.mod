@@ -10,6 +10,7 @@ require (
 	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
 	github.com/sirupsen/logrus v1.9.3
 	github.com/wamuir/graft v0.9.0
+	go.etcd.io/bbolt v1.3.11
 )
 
 require (
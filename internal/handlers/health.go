@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	predictionService "github.com/tonespy/ecosort_be/internal/services/prediction"
+)
+
+type HealthHandler struct {
+	PredictionService *predictionService.PredictionService
+}
+
+// Liveness reports that the process is up and serving HTTP.
+func (h *HealthHandler) Liveness(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Readiness reports whether the model is loaded and warmed up.
+func (h *HealthHandler) Readiness(c *gin.Context) {
+	modelLoaded := h.PredictionService.ModelLoaded()
+	warmedUp := h.PredictionService.IsReady()
+	response := gin.H{
+		"model_loaded": modelLoaded,
+		"warmed_up":    warmedUp,
+	}
+	if !modelLoaded || !warmedUp {
+		response["status"] = "not_ready"
+		c.JSON(http.StatusServiceUnavailable, response)
+		return
+	}
+	response["status"] = "ready"
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *HealthHandler) RegisterRoutes(router gin.IRoutes) {
+	router.GET("/healthz", h.Liveness)
+	router.GET("/readyz", h.Readiness)
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	predictionService "github.com/tonespy/ecosort_be/internal/services/prediction"
+)
+
+// imageJSONRequest is the JSON body accepted by the prediction endpoints in
+// place of uploaded files.
+type imageJSONRequest struct {
+	Image        string   `json:"image"`
+	Filename     string   `json:"filename"`
+	ImageURL     string   `json:"image_url"`
+	ImageURLs    []string `json:"image_urls"`
+	Priority     string   `json:"priority"`
+	CallbackURL  string   `json:"callback_url"`
+	MatchHistory bool     `json:"match_history"`
+}
+
+// isJSONRequest reports whether the request body is JSON rather than a form.
+func isJSONRequest(c *gin.Context) bool {
+	return c.ContentType() == "application/json"
+}
+
+// maxImageJSONSize bounds JSON prediction requests: a 50 MB image, the
+// largest accepted, grows by a third when base64 encoded.
+const maxImageJSONSize = 70 << 20
+
+// predictImageJSON classifies the base64 encoded image in the request's
+// image field, or the image at its image_url.
+func (h *PredictionHandler) predictImageJSON(c *gin.Context, options predictionService.PredictOptions) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImageJSONSize)
+	var request imageJSONRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		status, message := uploadErrorResponse(err, "Invalid request body")
+		c.JSON(status, gin.H{"error": message})
+		return
+	}
+
+	var result predictionService.PredictResult
+	var err error
+	switch {
+	case request.Image != "":
+		result, err = h.PredictionService.PredictImageData(request.Image, request.Filename, options)
+	case request.ImageURL != "":
+		result, err = h.PredictionService.PredictImageURL(c.Request.Context(), request.ImageURL, options)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Request body must contain an image or image_url"})
+		return
+	}
+	writePrediction(c, result, err)
+}
+
+// maxImageURLLength bounds each URL of a batch request; with
+// imageURLsJSONOverhead for the other fields, it also bounds the request body.
+const (
+	maxImageURLLength     = 2048
+	imageURLsJSONOverhead = 16 << 10
+)
+
+// batchPredictURLs creates a batch job from the request's image_urls. The job
+// is queued immediately and its images are downloaded in the background.
+func (h *PredictionHandler) batchPredictURLs(c *gin.Context) {
+	// Room for UploadMaxFiles URLs and image_url at the longest length, with
+	// slack for quoting and escaping
+	limit := int64(h.PredictionService.Config.UploadMaxFiles+1)*(maxImageURLLength*2+4) + imageURLsJSONOverhead
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+	var request imageJSONRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		status, message := uploadErrorResponse(err, "Invalid request body")
+		c.JSON(status, gin.H{"error": message})
+		return
+	}
+	urls := request.ImageURLs
+	if request.ImageURL != "" {
+		urls = append(urls, request.ImageURL)
+	}
+	if len(urls) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No image URLs given"})
+		return
+	}
+	if limit := h.PredictionService.Config.UploadMaxFiles; len(urls) > limit {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Too many images, at most %d per request", limit)})
+		return
+	}
+	for _, imageURL := range urls {
+		if len(imageURL) > maxImageURLLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Image URLs must be at most %d characters", maxImageURLLength)})
+			return
+		}
+		if err := predictionService.ValidateImageURL(imageURL); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s: %v", imageURL, err)})
+			return
+		}
+	}
+
+	options := predictionService.JobOptions{Priority: request.Priority, CallbackURL: request.CallbackURL, NoCache: noCache(c), MatchHistory: request.MatchHistory}
+	upload, err := h.startJob(c, &options)
+	if err != nil {
+		return
+	}
+	h.PredictionService.FetchJobImages(upload, urls)
+
+	c.JSON(http.StatusOK, gin.H{"jobID": upload.JobID, "status": predictionService.JobStatusQueued, "priority": options.Priority, "total": len(urls), "message": "Images are being fetched"})
+}
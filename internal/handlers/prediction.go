@@ -1,17 +1,21 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
-	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"github.com/gorilla/websocket"
 	"github.com/tonespy/ecosort_be/config"
+	"github.com/tonespy/ecosort_be/internal/middleware"
 	predictionService "github.com/tonespy/ecosort_be/internal/services/prediction"
 	"github.com/tonespy/ecosort_be/pkg/logger"
 )
@@ -20,80 +24,206 @@ type PredictionHandler struct {
 	PredictionService *predictionService.PredictionService
 }
 
+// uploadIdleTimeout is how long a batch upload may stall before it is aborted.
+// Jobs start while their upload is running, so a stalled upload holds a worker.
+const uploadIdleTimeout = 30 * time.Second
+
+// BatchPredict streams a multipart upload into a new batch job. Each file is
+// validated and spooled as it arrives and the job is queued with the first
+// file, so inference can start before the upload finishes. The "priority",
+// "callback_url" and "match_history" fields must therefore precede the files.
+// A single .zip, .tar or .tar.gz file is extracted, each image becoming a job
+// entry. A JSON body with "image_urls" creates the job from images fetched by
+// URL instead.
 func (h *PredictionHandler) BatchPredict(c *gin.Context) {
-	form, err := c.MultipartForm()
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid multipart form"})
+	if h.PredictionService.IsShuttingDown() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Server is shutting down"})
 		return
 	}
-
-	files := form.File["files"]
-	if len(files) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No files uploaded"})
+	if isJSONRequest(c) {
+		h.batchPredictURLs(c)
 		return
 	}
-	// h.PredictionService.Logger.Info("Batch predict", map[string]interface{}{"files": files})
 
-	// Generate a unique job ID.
-	jobID := uuid.New().String()
-	// Create a job-specific directory.
-	jobDir := filepath.Join("wsjobs", jobID)
-	if err := os.MkdirAll(jobDir, os.ModePerm); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create job directory"})
+	cfg := h.PredictionService.Config
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, cfg.UploadMaxSize)
+	c.Request.Body = &idleTimeoutBody{ReadCloser: c.Request.Body, controller: http.NewResponseController(c.Writer)}
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid multipart form"})
 		return
 	}
 
-	// Loop through each uploaded file.
-	for _, fileHeader := range files {
-		// Open the file to read its contents.
-		f, err := fileHeader.Open()
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to open file %s", fileHeader.Filename)})
-			return
+	options := predictionService.JobOptions{NoCache: noCache(c)}
+	var upload *predictionService.JobUpload
+	var archive *predictionService.ArchiveReport
+	files := 0
+	fail := func(status int, message string, cause error) {
+		if upload != nil {
+			h.PredictionService.FinishUpload(upload, cause)
 		}
+		c.JSON(status, gin.H{"error": message})
+	}
 
-		imageBytes, err := io.ReadAll(f)
-		f.Close()
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to read file %s", fileHeader.Filename)})
+			status, message := uploadErrorResponse(err, "Invalid multipart form")
+			fail(status, message, err)
 			return
 		}
 
-		// Decide whether to use the image bytes directly.
-		// For example, if we have non-zero bytes, process them in memory.
-		fullFileName := fileHeader.Filename
-		if filepath.Ext(fullFileName) != ".jpg" {
-			fullFileName += ".jpg"
-		}
-		savePath := filepath.Join(jobDir, fullFileName)
-		if len(imageBytes) > 0 {
-			// Process the image using the bytes.
-			// h.PredictionService.Logger.Info("Processing image from memory", map[string]interface{}{"file": fullFileName})
-
-			// Save the image data to disk.
-			err = os.WriteFile(savePath, imageBytes, 0644)
+		switch part.FormName() {
+		case "priority", "callback_url", "match_history":
+			if upload != nil {
+				fail(http.StatusBadRequest, "Form fields must precede the files", predictionService.ErrUploadFailed)
+				return
+			}
+			value, err := io.ReadAll(io.LimitReader(part, 2048))
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to save file %s", fileHeader.Filename)})
+				status, message := uploadErrorResponse(err, "Invalid multipart form")
+				fail(status, message, err)
+				return
+			}
+			switch part.FormName() {
+			case "priority":
+				options.Priority = string(value)
+			case "callback_url":
+				options.CallbackURL = string(value)
+			case "match_history":
+				options.MatchHistory = string(value) == "true"
+			}
+
+		case "files":
+			if part.FileName() == "" {
+				fail(http.StatusBadRequest, "Missing file name", predictionService.ErrUploadFailed)
+				return
+			}
+			if archive != nil || (files > 0 && predictionService.IsArchive(part.FileName())) {
+				fail(http.StatusBadRequest, "An archive must be the only file", predictionService.ErrUploadFailed)
+				return
+			}
+			if files >= cfg.UploadMaxFiles {
+				fail(http.StatusRequestEntityTooLarge, fmt.Sprintf("Too many files, at most %d per request", cfg.UploadMaxFiles), predictionService.ErrUploadFailed)
 				return
 			}
-		} else {
-			// Fallback: if no bytes were read, use the built-in SaveUploadedFile.
-			// h.PredictionService.Logger.Info("No image bytes; saving file using SaveUploadedFile", map[string]interface{}{"file": fileHeader.Filename})
-			if err := c.SaveUploadedFile(fileHeader, savePath); err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to save file %s", fileHeader.Filename)})
+			if upload == nil {
+				if upload, err = h.startJob(c, &options); err != nil {
+					return
+				}
+			}
+			if predictionService.IsArchive(part.FileName()) {
+				report, err := upload.AddArchive(part.FileName(), part)
+				if err != nil {
+					status, message := uploadErrorResponse(err, fmt.Sprintf("Failed to extract archive %s", part.FileName()))
+					fail(status, message, err)
+					return
+				}
+				archive = &report
+				files += report.Images
+				part.Close()
+				continue
+			}
+			if _, err := upload.Add(part.FileName(), part); err != nil {
+				status, message := uploadErrorResponse(err, fmt.Sprintf("Failed to read file %s", part.FileName()))
+				fail(status, message, err)
 				return
 			}
+			files++
 		}
+		part.Close()
 	}
 
-	// Start background processing.
-	go h.PredictionService.ProcessPredictions(jobID, files, jobDir)
+	if upload == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No files uploaded"})
+		return
+	}
+	if files == 0 {
+		fail(http.StatusBadRequest, "Archive contains no images", predictionService.ErrUploadFailed)
+		return
+	}
+	job, err := h.PredictionService.FinishUpload(upload, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record upload"})
+		return
+	}
 
 	// Return the job ID to the client.
-	c.JSON(http.StatusOK, gin.H{"jobID": jobID, "message": "Files uploaded successfully"})
+	response := gin.H{"jobID": job.JobID, "status": job.Status, "priority": job.Priority, "total": job.Total, "message": "Files uploaded successfully"}
+	if archive != nil {
+		response["archive"] = archive
+	}
+	c.JSON(http.StatusOK, response)
 }
 
-// PredictionsWebSocketHandler upgrades the connection and registers it.
+// startJob validates the job options and queues the job, writing the error
+// response when that fails.
+func (h *PredictionHandler) startJob(c *gin.Context, options *predictionService.JobOptions) (*predictionService.JobUpload, error) {
+	priority, err := predictionService.ParseJobPriority(options.Priority)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, err
+	}
+	options.Priority = priority
+	if options.CallbackURL != "" {
+		if err := h.PredictionService.ValidateCallbackURL(options.CallbackURL); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return nil, err
+		}
+	}
+
+	upload, err := h.PredictionService.StartJob(uuid.New().String(), middleware.ClientID(c), *options)
+	if errors.Is(err, predictionService.ErrShuttingDown) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Server is shutting down"})
+	} else if errors.Is(err, predictionService.ErrQueueFull) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Job queue is full, try again later"})
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create job"})
+	}
+	return upload, err
+}
+
+// uploadErrorResponse maps an error raised while reading an upload to a
+// status code and message, using fallback for malformed requests.
+func uploadErrorResponse(err error, fallback string) (int, string) {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		return http.StatusRequestEntityTooLarge, fmt.Sprintf("Upload exceeds %d bytes", maxBytesErr.Limit)
+	case errors.Is(err, predictionService.ErrArchiveTooLarge):
+		return http.StatusRequestEntityTooLarge, fmt.Sprintf("%s: %v", fallback, err)
+	case errors.Is(err, predictionService.ErrInvalidArchive):
+		return http.StatusUnprocessableEntity, fmt.Sprintf("%s: %v", fallback, err)
+	case errors.Is(err, predictionService.ErrFileTooLarge):
+		return http.StatusRequestEntityTooLarge, fmt.Sprintf("%s: file is too large", fallback)
+	case errors.Is(err, predictionService.ErrUnsupportedUpload):
+		return http.StatusUnsupportedMediaType, fmt.Sprintf("%s: %v", fallback, err)
+	case errors.Is(err, predictionService.ErrJobFinished):
+		return http.StatusConflict, "Job ended during upload"
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return http.StatusRequestTimeout, "Upload stalled"
+	default:
+		return http.StatusBadRequest, fallback
+	}
+}
+
+// idleTimeoutBody extends the connection's read deadline before every read,
+// so an upload may take as long as it needs but must keep making progress.
+type idleTimeoutBody struct {
+	io.ReadCloser
+	controller *http.ResponseController
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	b.controller.SetReadDeadline(time.Now().Add(uploadIdleTimeout))
+	return b.ReadCloser.Read(p)
+}
+
+// PredictionsWebSocketHandler upgrades the connection and streams the job's
+// updates to it. Any number of clients may watch the same job.
 func (h *PredictionHandler) PredictionsWebSocketHandler(c *gin.Context) {
 	jobID := c.Query("jobID")
 	if jobID == "" {
@@ -101,116 +231,325 @@ func (h *PredictionHandler) PredictionsWebSocketHandler(c *gin.Context) {
 		return
 	}
 
-	upgrader := h.PredictionService.GetUpgrader()
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	clientID := middleware.ClientID(c)
+	job, err := h.PredictionService.GetClientJob(jobID, clientID)
+	if errors.Is(err, predictionService.ErrJobNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
 	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load job"})
 		return
 	}
 
-	wsConnections := h.PredictionService.GetWsConnections()
-
-	// Register connection.
-	wsConnections.Lock()
-	wsConnections.Connections[jobID] = conn
-	wsConnections.Unlock()
-
-	// Send current progress immediately (if available).
-	jobProgressMap := h.PredictionService.GetJobProgressMap()
-	jobProgressMap.RLock()
-	if progress, ok := jobProgressMap.Data[jobID]; ok {
-		conn.WriteJSON(progress)
-		if progress.Progress == 100 {
-			delete(jobProgressMap.Data, jobID)
-			wsConnections.Connections[jobID].WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "Job completed"))
-			wsConnections.Connections[jobID].Close()
-			os.RemoveAll(filepath.Join("wsjobs", jobID))
+	// With fromSequence the client receives sequence-numbered events after N
+	// instead of a snapshot; 0 replays the job's full history.
+	from := int64(-1)
+	if value := c.Query("fromSequence"); value != "" {
+		from, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || from < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid fromSequence"})
+			return
 		}
 	}
-	jobProgressMap.RUnlock()
 
-	// Keep connection alive by reading (to detect disconnect).
-	for {
-		if _, _, err := conn.ReadMessage(); err != nil {
-			break
-		}
+	conn, err := h.PredictionService.WebSockets.Upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+	h.PredictionService.ServeJobWebSocket(conn, job, clientID, from)
+}
+
+// PredictionStreamHandler upgrades the connection to a prediction stream:
+// the client sends images as binary frames, each announced by a JSON
+// envelope, and receives their predictions on the same socket.
+func (h *PredictionHandler) PredictionStreamHandler(c *gin.Context) {
+	if h.PredictionService.IsShuttingDown() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Server is shutting down"})
+		return
+	}
+	if !h.PredictionService.IsReady() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Model is not ready"})
+		return
 	}
 
-	// Remove connection when closed.
-	wsConnections.Lock()
-	delete(wsConnections.Connections, jobID)
-	wsConnections.Unlock()
+	conn, err := h.PredictionService.WebSockets.Upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+	h.PredictionService.ServePredictionStream(conn, middleware.ClientID(c), predictionService.PredictOptions{NoCache: noCache(c)})
 }
 
 // JobProgressHandler returns the current progress for a given jobID.
 func (h *PredictionHandler) JobProgressHandler(c *gin.Context) {
-	jobID := c.Query("jobID")
-	if jobID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing jobID"})
+	job, err := h.PredictionService.GetClientJob(c.Param("id"), middleware.ClientID(c))
+	if errors.Is(err, predictionService.ErrJobNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load job"})
 		return
 	}
+	c.JSON(http.StatusOK, job)
+}
 
-	jobProgressMap := h.PredictionService.GetJobProgressMap()
-	jobProgressMap.RLock()
-	progress, ok := jobProgressMap.Data[jobID]
-	jobProgressMap.RUnlock()
-	if !ok {
+// CancelJobHandler cancels a queued or running job and returns its final state.
+func (h *PredictionHandler) CancelJobHandler(c *gin.Context) {
+	job, err := h.PredictionService.CancelJob(c.Request.Context(), c.Param("id"), middleware.ClientID(c))
+	switch {
+	case errors.Is(err, predictionService.ErrJobNotFound):
 		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+	case errors.Is(err, predictionService.ErrJobFinished):
+		response := gin.H{"error": "Job already finished"}
+		if job != nil {
+			response["status"] = job.Status
+		}
+		c.JSON(http.StatusConflict, response)
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel job"})
+	default:
+		c.JSON(http.StatusOK, job)
+	}
+}
+
+// JobEventsHandler streams a job's updates as Server-Sent Events. Every event
+// carries its sequence number as the SSE id, so a reconnecting client sending
+// Last-Event-ID receives the updates it missed.
+func (h *PredictionHandler) JobEventsHandler(c *gin.Context) {
+	job, err := h.PredictionService.GetClientJob(c.Param("id"), middleware.ClientID(c))
+	if errors.Is(err, predictionService.ErrJobNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load job"})
+		return
+	}
+
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("lastEventId")
+	}
+	var after int64
+	if lastEventID != "" {
+		after, err = strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil || after < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Last-Event-ID"})
+			return
+		}
+	}
+
+	backlog, subscription, known := h.PredictionService.SubscribeJobEvents(job.JobID, after)
+	if subscription != nil {
+		defer subscription.Unsubscribe()
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	// Without an event log (e.g. after a restart) start from a snapshot of the job.
+	if !known && len(backlog) == 0 {
+		writeSSE(c, "", predictionService.JobEventSnapshot, job)
+		if job.IsTerminal() {
+			return
+		}
+	}
+	for _, event := range backlog {
+		writeSSE(c, strconv.FormatInt(event.Sequence, 10), event.Type, event.Data)
+		if event.Terminal() {
+			return
+		}
+	}
+	if subscription == nil {
 		return
 	}
-	c.JSON(http.StatusOK, progress)
+
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-keepAlive.C:
+			c.Writer.WriteString(": keep-alive\n\n")
+			c.Writer.Flush()
+		case event, ok := <-subscription.C:
+			if !ok {
+				// Dropped for falling behind; the client reconnects with Last-Event-ID.
+				return
+			}
+			writeSSE(c, strconv.FormatInt(event.Sequence, 10), event.Type, event.Data)
+			if event.Terminal() {
+				return
+			}
+		}
+	}
 }
 
-func (h *PredictionHandler) PredictImage(c *gin.Context) {
-	// Log header and multipart form data
-	// Debug: Log all form fields
-	_, err := c.MultipartForm()
+// writeSSE writes a single Server-Sent Event with a JSON payload and flushes it.
+func writeSSE(c *gin.Context, id string, event string, data any) {
+	payload, err := json.Marshal(data)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to parse multipart form", "details": err.Error()})
+		return
+	}
+	if id != "" {
+		fmt.Fprintf(c.Writer, "id: %s\n", id)
+	}
+	fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", event, payload)
+	c.Writer.Flush()
+}
+
+// JobDeliveriesHandler returns the webhook delivery log of a job.
+func (h *PredictionHandler) JobDeliveriesHandler(c *gin.Context) {
+	job, err := h.PredictionService.GetClientJob(c.Param("id"), middleware.ClientID(c))
+	if errors.Is(err, predictionService.ErrJobNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load job"})
 		return
 	}
 
-	// Log all form fields
-	// for key, values := range form.Value {
-	// 	h.PredictionService.Logger.Info("Form field", map[string]interface{}{"key": key, "values": values})
-	// }
+	deliveries := job.Deliveries
+	if deliveries == nil {
+		deliveries = []predictionService.WebhookDelivery{}
+	}
+	c.JSON(http.StatusOK, gin.H{"jobID": job.JobID, "callbackURL": job.CallbackURL, "deliveries": deliveries})
+}
 
-	// // Log all uploaded files
-	// for key, files := range form.File {
-	// 	for _, file := range files {
-	// 		h.PredictionService.Logger.Info("File field", map[string]interface{}{"key": key, "file": file})
-	// 	}
-	// }
+// ListJobsHandler returns a page of the caller's jobs, newest first.
+// Supports ?status=running,completed&limit=20&offset=0.
+func (h *PredictionHandler) ListJobsHandler(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 || limit > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "offset must be a non-negative integer"})
+		return
+	}
 
-	// Get the uploaded file
-	file, err := c.FormFile("file")
+	var statuses []string
+	for _, value := range c.QueryArray("status") {
+		for _, status := range strings.Split(value, ",") {
+			if status = strings.TrimSpace(status); status != "" {
+				statuses = append(statuses, status)
+			}
+		}
+	}
+
+	jobs, total, err := h.PredictionService.ListJobs(predictionService.JobFilter{
+		ClientID: middleware.ClientID(c),
+		Statuses: statuses,
+		Offset:   offset,
+		Limit:    limit,
+	})
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to get file"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list jobs"})
 		return
 	}
 
-	// Validate the file
-	tempFile, err := h.PredictionService.ValidateAndGetTemp(file)
+	c.JSON(http.StatusOK, gin.H{
+		"jobs":   jobs,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// maxPredictRequestSize bounds single-image uploads: the largest accepted
+// image plus room for the multipart framing.
+const maxPredictRequestSize = 51 << 20
+
+// PredictImage classifies the uploaded "file". The image is decoded straight
+// from the multipart stream, without a temporary file for typical sizes.
+// Resubmitted images are answered from the result cache unless the request
+// has cache=false. With mode=detect the items of a mixed photo are located
+// instead, with the share of the photo each class covers.
+func (h *PredictionHandler) PredictImage(c *gin.Context) {
+	mode, err := predictionService.ParsePredictMode(c.Query("mode"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	options := predictionService.PredictOptions{NoCache: noCache(c), Mode: mode}
+	if isJSONRequest(c) {
+		h.predictImageJSON(c, options)
+		return
+	}
 
-	// Save temporarily
-	err = c.SaveUploadedFile(file, tempFile)
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxPredictRequestSize)
+	reader, err := c.Request.MultipartReader()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save file"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to parse multipart form", "details": err.Error()})
 		return
 	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to get file"})
+			return
+		}
+		if err != nil {
+			status, message := uploadErrorResponse(err, "failed to parse multipart form")
+			c.JSON(status, gin.H{"error": message})
+			return
+		}
+		if part.FormName() == "file" {
+			result, err := h.PredictionService.PredictReader(part, part.FileName(), options)
+			writePrediction(c, result, err)
+			return
+		}
+		part.Close()
+	}
+}
 
-	// Predict the image
-	prediction, err := h.PredictionService.PredictImage(tempFile)
-	if err != nil {
+// writePrediction writes the response for a single-image prediction. Images
+// that can't be read or decoded are the client's fault; inference errors are
+// not. Images failing the quality gate are refused with the reasons.
+func writePrediction(c *gin.Context, result predictionService.PredictResult, err error) {
+	var imageErr *predictionService.ImageError
+	var qualityErr *predictionService.QualityError
+	switch {
+	case errors.As(err, &qualityErr):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "image quality too low", "code": predictionService.ImageErrorPoorQuality, "reasons": qualityErr.Issues})
+	case errors.As(err, &imageErr) && imageErr.Code != predictionService.ImageErrorInference && imageErr.Code != predictionService.ImageErrorTimeout:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to load image", "code": imageErr.Code, "details": imageErr.Err.Error()})
+	case errors.As(err, &imageErr):
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to predict image", "details": err.Error()})
-		return
+	case err != nil:
+		status, message := uploadErrorResponse(err, "failed to read file")
+		c.JSON(status, gin.H{"error": message})
+	case result.Detection != nil:
+		response := gin.H{"detections": result.Detection.Detections, "composition": result.Detection.Composition, "cached": result.Cached}
+		if len(result.Warnings) > 0 {
+			response["warnings"] = result.Warnings
+		}
+		c.JSON(http.StatusOK, response)
+	default:
+		response := gin.H{"prediction": result.Class, "cached": result.Cached}
+		if len(result.Warnings) > 0 {
+			response["warnings"] = result.Warnings
+		}
+		c.JSON(http.StatusOK, response)
 	}
+}
+
+// noCache reports whether the request opts out of cached results with cache=false.
+func noCache(c *gin.Context) bool {
+	return c.Query("cache") == "false"
+}
 
-	// Return the prediction
-	c.JSON(http.StatusOK, gin.H{"prediction": prediction})
+// CacheStatsHandler reports the result cache's size and hit ratio.
+func (h *PredictionHandler) CacheStatsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, h.PredictionService.Cache.Stats())
 }
 
 func (h *PredictionHandler) GetConfig(c *gin.Context) {
@@ -226,16 +565,41 @@ func (h *PredictionHandler) GetConfig(c *gin.Context) {
 }
 
 func BuildPredictionHandler(config *config.Config, logger *logger.Logger) *PredictionHandler {
+	jobStore, err := predictionService.NewJobStore(config)
+	if err != nil {
+		log.Fatalf("Job store initialization failed: %v", err)
+	}
+
 	predictionService := &predictionService.PredictionService{
-		Config: config,
-		Logger: logger,
+		Config:     config,
+		Logger:     logger,
+		JobStore:   jobStore,
+		Webhooks:   predictionService.NewWebhookNotifier(config, jobStore, logger),
+		Events:     predictionService.NewJobEventBus(),
+		WebSockets: predictionService.NewWebSocketHub(),
+		Fetcher:    predictionService.NewImageFetcher(config),
+		Cache:      predictionService.NewResultCache(config.ResultCacheSize, config.ResultCacheTTL),
+	}
+
+	// Jobs interrupted by a previous restart can't resume; mark them stopped.
+	if err := predictionService.RecoverJobs(); err != nil {
+		log.Fatalf("Job recovery failed: %v", err)
 	}
+	predictionService.StartJobWorkers()
+	predictionService.StartJanitor()
 
 	// Initialize the shared TensorFlow model.
 	if err := predictionService.InitModel(); err != nil {
 		log.Fatalf("Model initialization failed: %v", err)
 	}
 
+	// Warm up in the background so liveness checks pass while the graph initializes.
+	go func() {
+		if err := predictionService.WarmUpModel(); err != nil {
+			logger.Error("Model warm-up failed", map[string]interface{}{"error": err.Error()}, err)
+		}
+	}()
+
 	return &PredictionHandler{
 		PredictionService: predictionService,
 	}
@@ -245,5 +609,18 @@ func (h *PredictionHandler) RegisterRoutes(router *gin.RouterGroup) {
 	router.POST("/predict", h.PredictImage)
 	router.POST("/predict/batch", h.BatchPredict)
 	router.GET("/predict/websocket", h.PredictionsWebSocketHandler)
+	router.GET("/predict/stream", h.PredictionStreamHandler)
 	router.GET("/predict/config", h.GetConfig)
+	router.GET("/predict/cache", h.CacheStatsHandler)
+	router.GET("/jobs", h.ListJobsHandler)
+	router.GET("/jobs/:id", h.JobProgressHandler)
+	router.DELETE("/jobs/:id", h.CancelJobHandler)
+	router.GET("/jobs/:id/events", h.JobEventsHandler)
+	router.GET("/jobs/:id/deliveries", h.JobDeliveriesHandler)
+	router.POST("/uploads", h.CreateUploadHandler)
+	router.GET("/uploads/:id", h.GetUploadHandler)
+	router.DELETE("/uploads/:id", h.DeleteUploadHandler)
+	router.HEAD("/uploads/:id/files/:index", h.UploadOffsetHandler)
+	router.PATCH("/uploads/:id/files/:index", h.UploadChunkHandler)
+	router.POST("/uploads/:id/finalize", h.FinalizeUploadHandler)
 }
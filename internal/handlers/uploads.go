@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tonespy/ecosort_be/internal/middleware"
+	predictionService "github.com/tonespy/ecosort_be/internal/services/prediction"
+)
+
+// Headers of the resumable upload protocol.
+const (
+	uploadOffsetHeader = "Upload-Offset"
+	uploadLengthHeader = "Upload-Length"
+	// uploadChunkContentType is required for chunk requests.
+	uploadChunkContentType = "application/offset+octet-stream"
+)
+
+// CreateUploadHandler starts a resumable batch upload. The body lists the
+// files with their sizes, plus the optional job priority and callback URL.
+func (h *PredictionHandler) CreateUploadHandler(c *gin.Context) {
+	var request struct {
+		Priority    string `json:"priority"`
+		CallbackURL string `json:"callback_url"`
+		Files       []struct {
+			Name string `json:"name"`
+			Size int64  `json:"size"`
+		} `json:"files"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	priority, err := predictionService.ParseJobPriority(request.Priority)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if request.CallbackURL != "" {
+		if err := h.PredictionService.ValidateCallbackURL(request.CallbackURL); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	var files []predictionService.UploadFile
+	for _, file := range request.Files {
+		files = append(files, predictionService.UploadFile{Name: file.Name, Size: file.Size})
+	}
+
+	session, err := h.PredictionService.CreateUploadSession(middleware.ClientID(c), predictionService.JobOptions{
+		Priority:    priority,
+		CallbackURL: request.CallbackURL,
+	}, files)
+	if errors.Is(err, predictionService.ErrShuttingDown) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Server is shutting down"})
+		return
+	}
+	if errors.Is(err, predictionService.ErrInvalidUpload) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create upload"})
+		return
+	}
+
+	c.Header("Location", c.Request.URL.Path+"/"+session.UploadID)
+	c.JSON(http.StatusCreated, session)
+}
+
+// GetUploadHandler returns an upload session with the offset of every file,
+// so a client can resume after losing its connection.
+func (h *PredictionHandler) GetUploadHandler(c *gin.Context) {
+	session, err := h.PredictionService.GetUploadSession(c.Param("id"), middleware.ClientID(c))
+	if err != nil {
+		writeUploadError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, session)
+}
+
+// UploadOffsetHandler reports a file's current offset in the Upload-Offset header.
+func (h *PredictionHandler) UploadOffsetHandler(c *gin.Context) {
+	file, ok := h.uploadFile(c)
+	if !ok {
+		return
+	}
+	c.Header(uploadOffsetHeader, strconv.FormatInt(file.Offset, 10))
+	c.Header(uploadLengthHeader, strconv.FormatInt(file.Size, 10))
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+}
+
+// UploadChunkHandler appends the request body to a file at the offset given in
+// the Upload-Offset header and returns the new offset. The bytes received
+// before a dropped connection are kept.
+func (h *PredictionHandler) UploadChunkHandler(c *gin.Context) {
+	if c.ContentType() != uploadChunkContentType {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be " + uploadChunkContentType})
+		return
+	}
+	index, err := strconv.Atoi(c.Param("index"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader(uploadOffsetHeader), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Upload-Offset header"})
+		return
+	}
+
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, h.PredictionService.Config.UploadMaxFileSize)
+	c.Request.Body = &idleTimeoutBody{ReadCloser: body, controller: http.NewResponseController(c.Writer)}
+	newOffset, err := h.PredictionService.WriteUploadChunk(c.Param("id"), middleware.ClientID(c), index, offset, c.Request.Body)
+	if newOffset > 0 || err == nil {
+		c.Header(uploadOffsetHeader, strconv.FormatInt(newOffset, 10))
+	}
+	if err != nil {
+		writeUploadError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// FinalizeUploadHandler turns a complete upload into a batch job with the
+// upload's ID.
+func (h *PredictionHandler) FinalizeUploadHandler(c *gin.Context) {
+	job, err := h.PredictionService.FinalizeUpload(c.Param("id"), middleware.ClientID(c))
+	if err != nil {
+		writeUploadError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"jobID": job.JobID, "status": job.Status, "priority": job.Priority, "total": job.Total, "message": "Upload finalized"})
+}
+
+// DeleteUploadHandler aborts an upload and discards its chunks.
+func (h *PredictionHandler) DeleteUploadHandler(c *gin.Context) {
+	if err := h.PredictionService.DeleteUploadSession(c.Param("id"), middleware.ClientID(c)); err != nil {
+		writeUploadError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// uploadFile looks up the file addressed by the request, writing a 404 if it
+// doesn't exist.
+func (h *PredictionHandler) uploadFile(c *gin.Context) (predictionService.UploadFile, bool) {
+	session, err := h.PredictionService.GetUploadSession(c.Param("id"), middleware.ClientID(c))
+	if err != nil {
+		writeUploadError(c, err)
+		return predictionService.UploadFile{}, false
+	}
+	index, err := strconv.Atoi(c.Param("index"))
+	if err != nil || index < 0 || index >= len(session.Files) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+		return predictionService.UploadFile{}, false
+	}
+	return session.Files[index], true
+}
+
+// writeUploadError maps resumable upload errors to responses.
+func writeUploadError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, predictionService.ErrUploadNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+	case errors.Is(err, predictionService.ErrOffsetMismatch):
+		c.JSON(http.StatusConflict, gin.H{"error": "Upload-Offset does not match the current offset"})
+	case errors.Is(err, predictionService.ErrChunkTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+	case errors.Is(err, predictionService.ErrUploadIncomplete):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, predictionService.ErrUnsupportedUpload):
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+	case errors.Is(err, predictionService.ErrShuttingDown):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Server is shutting down"})
+	case errors.Is(err, predictionService.ErrQueueFull):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Job queue is full, try again later"})
+	default:
+		// The connection most likely dropped mid-chunk; the client resumes
+		// from the offset reported by HEAD.
+		status, message := uploadErrorResponse(err, "Failed to store upload")
+		if status == http.StatusBadRequest {
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": message})
+	}
+}
@@ -1,19 +1,43 @@
 package middleware
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 )
 
-func DefaultClientAuth(recognizedKey string) gin.HandlerFunc {
+// ClientIDKey is the gin context key holding the caller's client ID.
+const ClientIDKey = "clientID"
+
+// DefaultClientAuth accepts requests carrying one of the recognized API keys
+// and identifies the caller by the key used.
+func DefaultClientAuth(recognizedKeys []string) gin.HandlerFunc {
+	recognized := make(map[string]bool, len(recognizedKeys))
+	for _, key := range recognizedKeys {
+		recognized[key] = true
+	}
 	return func(c *gin.Context) {
 		apiKey := c.GetHeader("X-API-Key")
-		if apiKey != recognizedKey {
+		if !recognized[apiKey] {
 			c.JSON(http.StatusNotImplemented, gin.H{})
 			c.Abort()
 			return
 		}
+		c.Set(ClientIDKey, clientIDFromKey(apiKey))
 		c.Next()
 	}
 }
+
+// ClientID returns the ID of the authenticated caller, or "" when unauthenticated.
+func ClientID(c *gin.Context) string {
+	return c.GetString(ClientIDKey)
+}
+
+// clientIDFromKey derives a stable identifier from an API key so records can
+// be scoped per key without storing the key itself.
+func clientIDFromKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:8])
+}
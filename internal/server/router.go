@@ -1,6 +1,8 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -13,6 +15,8 @@ import (
 type Server struct {
 	Logger *logger.Logger
 	Config *config.Config
+
+	predictionHandler *handlers.PredictionHandler
 }
 
 func (s *Server) NewRouter() *gin.Engine {
@@ -21,9 +25,15 @@ func (s *Server) NewRouter() *gin.Engine {
 
 	// Create handlers
 	predictionHandler := handlers.BuildPredictionHandler(s.Config, s.Logger)
+	s.predictionHandler = predictionHandler
+	healthHandler := &handlers.HealthHandler{PredictionService: predictionHandler.PredictionService}
+
+	// Health checks are registered before the auth middleware so platform
+	// healthchecks don't need the API key.
+	healthHandler.RegisterRoutes(router)
 
 	// Apply middleware
-	router.Use(middleware.DefaultClientAuth(s.Config.APIKey))
+	router.Use(middleware.DefaultClientAuth(s.Config.APIKeys))
 
 	// No route handler
 	router.NoRoute(func(c *gin.Context) {
@@ -38,3 +48,28 @@ func (s *Server) NewRouter() *gin.Engine {
 	predictionHandler.RegisterRoutes(groupV1)
 	return router
 }
+
+// Shutdown gracefully stops the HTTP server while draining running prediction
+// jobs, then releases the model. Both share the deadline carried by ctx. The
+// drain runs alongside, since job event streams only end with their job.
+func (s *Server) Shutdown(ctx context.Context, httpServer *http.Server) error {
+	if s.predictionHandler == nil {
+		return s.shutdownHTTP(ctx, httpServer)
+	}
+
+	service := s.predictionHandler.PredictionService
+	drained := make(chan error, 1)
+	go func() {
+		drained <- service.Shutdown(ctx)
+	}()
+	httpErr := s.shutdownHTTP(ctx, httpServer)
+	return errors.Join(httpErr, <-drained, service.Close())
+}
+
+func (s *Server) shutdownHTTP(ctx context.Context, httpServer *http.Server) error {
+	err := httpServer.Shutdown(ctx)
+	if err != nil {
+		s.Logger.Error("HTTP server shutdown failed", map[string]interface{}{"error": err.Error()}, err)
+	}
+	return err
+}
@@ -0,0 +1,201 @@
+package prediction
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+var (
+	// ErrArchiveTooLarge is returned when an archive exceeds the entry count or
+	// uncompressed size limits.
+	ErrArchiveTooLarge = errors.New("archive exceeds extraction limits")
+	// ErrInvalidArchive is returned for archives that can't be read or contain
+	// entries escaping the archive root.
+	ErrInvalidArchive = errors.New("invalid archive")
+)
+
+// archiveLimits bound what a single archive upload may expand to.
+type archiveLimits struct {
+	maxEntries int
+	maxSize    int64
+}
+
+// ArchiveReport summarizes an extracted archive.
+type ArchiveReport struct {
+	Images  int `json:"images"`
+	Skipped int `json:"skipped"` // Directories, hidden files and entries that aren't images
+}
+
+// IsArchive reports whether filename names a supported archive: .zip, .tar,
+// .tar.gz or .tgz.
+func IsArchive(filename string) bool {
+	name := strings.ToLower(filename)
+	for _, ext := range []string{".zip", ".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddArchive extracts the images of an archive read from r into the upload,
+// each keyed by its path inside the archive. ZIP archives are spooled to the
+// job directory first since they need random access; TAR archives are
+// extracted as they stream in.
+func (u *JobUpload) AddArchive(filename string, r io.Reader) (ArchiveReport, error) {
+	extractor := &archiveExtractor{upload: u, remaining: u.limits.maxSize}
+
+	name := strings.ToLower(filename)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return extractor.report, extractor.zip(r)
+	case strings.HasSuffix(name, ".tar"):
+		return extractor.report, extractor.tar(r)
+	default:
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return extractor.report, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+		}
+		defer gz.Close()
+		return extractor.report, extractor.tar(gz)
+	}
+}
+
+// archiveExtractor adds archive entries to an upload while enforcing the
+// archive limits. remaining counts the uncompressed bytes still allowed;
+// entries are measured as they are read, since archive headers can lie.
+type archiveExtractor struct {
+	upload    *JobUpload
+	entries   int
+	remaining int64
+	report    ArchiveReport
+}
+
+func (e *archiveExtractor) zip(r io.Reader) error {
+	spool, err := os.CreateTemp(e.upload.dir, ".archive-*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	size, err := io.Copy(spool, r)
+	if err != nil {
+		return err
+	}
+	archive, err := zip.NewReader(spool, size)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+
+	for _, file := range archive.File {
+		if err := e.count(); err != nil {
+			return err
+		}
+		if !file.Mode().IsRegular() || skipArchiveEntry(file.Name) {
+			e.report.Skipped++
+			continue
+		}
+		entry, err := file.Open()
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrInvalidArchive, file.Name, err)
+		}
+		err = e.add(file.Name, entry)
+		entry.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *archiveExtractor) tar(r io.Reader) error {
+	archive := tar.NewReader(r)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+		}
+		if err := e.count(); err != nil {
+			return err
+		}
+		// Links and other special entries are never followed.
+		if header.Typeflag != tar.TypeReg || skipArchiveEntry(header.Name) {
+			e.report.Skipped++
+			continue
+		}
+		if err := e.add(header.Name, archive); err != nil {
+			return err
+		}
+	}
+}
+
+// count records another entry against the entry limit.
+func (e *archiveExtractor) count() error {
+	e.entries++
+	if e.entries > e.upload.limits.maxEntries {
+		return fmt.Errorf("%w: more than %d entries", ErrArchiveTooLarge, e.upload.limits.maxEntries)
+	}
+	return nil
+}
+
+// add spools one entry into the upload. Entries that aren't images are
+// skipped; an entry path escaping the archive root fails the whole archive.
+func (e *archiveExtractor) add(name string, r io.Reader) error {
+	name = path.Clean(strings.ReplaceAll(name, "\\", "/"))
+	if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+		return fmt.Errorf("%w: illegal file path: %s", ErrInvalidArchive, name)
+	}
+
+	limited := &archiveSizeLimit{r: r, remaining: &e.remaining, maxSize: e.upload.limits.maxSize}
+	_, err := e.upload.Add(name, limited)
+	if errors.Is(err, ErrUnsupportedUpload) {
+		e.report.Skipped++
+		// Count the rest of the skipped entry against the size limit too.
+		_, err = io.Copy(io.Discard, limited)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	e.report.Images++
+	return nil
+}
+
+// skipArchiveEntry reports whether an entry is metadata rather than content,
+// such as macOS resource forks or hidden files.
+func skipArchiveEntry(name string) bool {
+	for _, segment := range strings.Split(strings.ReplaceAll(name, "\\", "/"), "/") {
+		if segment == "__MACOSX" || strings.HasPrefix(segment, ".") && segment != "." && segment != ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// archiveSizeLimit fails with ErrArchiveTooLarge once the archive's entries
+// have produced more than the allowed uncompressed bytes.
+type archiveSizeLimit struct {
+	r         io.Reader
+	remaining *int64
+	maxSize   int64
+}
+
+func (l *archiveSizeLimit) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	*l.remaining -= int64(n)
+	if *l.remaining < 0 {
+		return n, fmt.Errorf("%w: more than %d uncompressed bytes", ErrArchiveTooLarge, l.maxSize)
+	}
+	return n, err
+}
@@ -0,0 +1,161 @@
+package prediction
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// archiveEntry is a file to put into a test archive.
+type archiveEntry struct {
+	name string
+	data []byte
+}
+
+// archiveFormats builds an archive of each supported format from entries,
+// keyed by the file name it is uploaded under.
+var archiveFormats = map[string]func(t *testing.T, entries []archiveEntry) []byte{
+	"images.zip":    buildZip,
+	"images.tar":    buildTar,
+	"images.tar.gz": buildTarGz,
+}
+
+func buildZip(t *testing.T, entries []archiveEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		f, err := w.Create(entry.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(entry.data)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func buildTar(t *testing.T, entries []archiveEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0644, Size: int64(len(entry.data)), Typeflag: tar.TypeReg}
+		if err := w.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		w.Write(entry.data)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func buildTarGz(t *testing.T, entries []archiveEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(buildTar(t, entries))
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// newArchiveUpload returns an upload spooling into a temporary directory.
+func newArchiveUpload(t *testing.T, limits archiveLimits) *JobUpload {
+	t.Helper()
+	return &JobUpload{
+		ctx:         context.Background(),
+		dir:         t.TempDir(),
+		maxFileSize: 10 << 20,
+		limits:      limits,
+		running:     &runningJob{},
+		wake:        func() {},
+	}
+}
+
+func testJPEG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 32, 32)), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+var defaultArchiveLimits = archiveLimits{maxEntries: 100, maxSize: 10 << 20}
+
+func TestAddArchiveExtractsImages(t *testing.T) {
+	entries := []archiveEntry{
+		{"a.jpg", testJPEG(t)},
+		{"nested/b.jpg", testJPEG(t)},
+		{"notes.txt", []byte("not an image")},
+		{"__MACOSX/._a.jpg", []byte("resource fork")},
+	}
+	for filename, build := range archiveFormats {
+		upload := newArchiveUpload(t, defaultArchiveLimits)
+		report, err := upload.AddArchive(filename, bytes.NewReader(build(t, entries)))
+		if err != nil {
+			t.Fatalf("%s: AddArchive: %v", filename, err)
+		}
+		if report.Images != 2 || report.Skipped != 2 {
+			t.Fatalf("%s: report = %+v, want 2 images and 2 skipped", filename, report)
+		}
+		if total, _ := upload.count(); total != 2 {
+			t.Fatalf("%s: upload holds %d images, want 2", filename, total)
+		}
+	}
+}
+
+func TestAddArchiveRejectsEscapingPaths(t *testing.T) {
+	for _, name := range []string{"../evil.jpg", "nested/../../evil.jpg", "/tmp/evil.jpg", "..\\evil.jpg"} {
+		for filename, build := range archiveFormats {
+			upload := newArchiveUpload(t, defaultArchiveLimits)
+			_, err := upload.AddArchive(filename, bytes.NewReader(build(t, []archiveEntry{{name, testJPEG(t)}})))
+			if !errors.Is(err, ErrInvalidArchive) {
+				t.Fatalf("%s with %q: error = %v, want %v", filename, name, err, ErrInvalidArchive)
+			}
+			if total, _ := upload.count(); total != 0 {
+				t.Fatalf("%s with %q: upload holds %d images, want none", filename, name, total)
+			}
+			if _, err := os.Stat(filepath.Join(filepath.Dir(upload.dir), "evil.jpg")); !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("%s with %q: file written outside the job directory", filename, name)
+			}
+		}
+	}
+}
+
+func TestAddArchiveEntryLimit(t *testing.T) {
+	entries := []archiveEntry{{"a.jpg", testJPEG(t)}, {"b.jpg", testJPEG(t)}, {"c.txt", []byte("text")}}
+	for filename, build := range archiveFormats {
+		upload := newArchiveUpload(t, archiveLimits{maxEntries: 2, maxSize: 10 << 20})
+		_, err := upload.AddArchive(filename, bytes.NewReader(build(t, entries)))
+		if !errors.Is(err, ErrArchiveTooLarge) {
+			t.Fatalf("%s: error = %v, want %v", filename, err, ErrArchiveTooLarge)
+		}
+	}
+}
+
+func TestAddArchiveUncompressedSizeLimit(t *testing.T) {
+	// Zeros compress to almost nothing; skipped entries still count.
+	entries := []archiveEntry{{"a.jpg", testJPEG(t)}, {"zeros.bin", make([]byte, 2<<20)}}
+	for filename, build := range archiveFormats {
+		upload := newArchiveUpload(t, archiveLimits{maxEntries: 100, maxSize: 1 << 20})
+		_, err := upload.AddArchive(filename, bytes.NewReader(build(t, entries)))
+		if !errors.Is(err, ErrArchiveTooLarge) {
+			t.Fatalf("%s: error = %v, want %v", filename, err, ErrArchiveTooLarge)
+		}
+	}
+}
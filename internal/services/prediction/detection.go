@@ -0,0 +1,286 @@
+package prediction
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"slices"
+
+	"github.com/tonespy/ecosort_be/config"
+)
+
+// Prediction modes: classify the whole photo as one item, or detect the
+// items of a mixed photo.
+const (
+	PredictModeClassify = "classify"
+	PredictModeDetect   = "detect"
+)
+
+// ParsePredictMode validates a client supplied prediction mode, defaulting to
+// PredictModeClassify.
+func ParsePredictMode(mode string) (string, error) {
+	switch mode {
+	case "", PredictModeClassify:
+		return PredictModeClassify, nil
+	case PredictModeDetect:
+		return PredictModeDetect, nil
+	default:
+		return "", fmt.Errorf("invalid mode %q, must be classify or detect", mode)
+	}
+}
+
+// BoundingBox is a rectangle in the pixel coordinates of the original image.
+type BoundingBox struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// Detection is an item found in a photo.
+type Detection struct {
+	Box        BoundingBox    `json:"box"`
+	Class      config.Classes `json:"class"`
+	Group      string         `json:"group,omitempty"`
+	Confidence float64        `json:"confidence"` // 0 to 1
+	// Area is the number of pixels covered, which can be less than the box.
+	// Detectors that leave it 0 are taken to cover their whole box.
+	Area int `json:"area"`
+}
+
+// coveredArea returns the pixels a detection covers.
+func (d Detection) coveredArea() int {
+	if d.Area > 0 {
+		return d.Area
+	}
+	return d.Box.Width * d.Box.Height
+}
+
+// CompositionShare is the percentage of a photo's area covered by a class or group.
+type CompositionShare struct {
+	Name    string  `json:"name"`
+	Percent float64 `json:"percent"`
+}
+
+// Composition summarizes what a photo is made of by area, largest share first.
+// Unclassified is the area no item was detected in with enough confidence.
+type Composition struct {
+	Classes      []CompositionShare `json:"classes"`
+	Groups       []CompositionShare `json:"groups"`
+	Unclassified float64            `json:"unclassified"`
+}
+
+// DetectionResult is the outcome of a prediction in detection mode.
+type DetectionResult struct {
+	Detections  []Detection `json:"detections"`
+	Composition Composition `json:"composition"`
+}
+
+// ObjectDetector finds the items in a photo.
+type ObjectDetector interface {
+	Detect(img image.Image) ([]Detection, error)
+}
+
+// tileDetector detects items with the classifier alone: the photo is split into
+// a grid of tiles that are classified one by one, and neighbouring tiles of the
+// same class are merged into a single item. Images with fewer pixels per side
+// than the grid has cells get one tile per pixel. A dedicated detection model
+// can replace it behind the ObjectDetector interface.
+type tileDetector struct {
+	grid          int
+	minConfidence float64
+	classify      func(tensorData [][][]float32) ([]float32, error)
+	classes       []config.Classes
+	groups        map[int]string // Class index to group name
+}
+
+// tile is a classified grid cell.
+type tile struct {
+	bounds     image.Rectangle
+	class      int
+	confidence float64
+}
+
+func (d *tileDetector) Detect(img image.Image) ([]Detection, error) {
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return nil, fmt.Errorf("image is empty")
+	}
+	cols, rows := min(d.grid, bounds.Dx()), min(d.grid, bounds.Dy())
+	grid := make([][]*tile, rows)
+	for row := range grid {
+		grid[row] = make([]*tile, cols)
+		for col := range grid[row] {
+			cell := image.Rect(
+				bounds.Min.X+col*bounds.Dx()/cols,
+				bounds.Min.Y+row*bounds.Dy()/rows,
+				bounds.Min.X+(col+1)*bounds.Dx()/cols,
+				bounds.Min.Y+(row+1)*bounds.Dy()/rows,
+			)
+			probabilities, err := d.classify(imageTensor(cropImage(img, cell)))
+			if err != nil {
+				return nil, err
+			}
+			class := getPredictedClass(probabilities)
+			if confidence := float64(probabilities[class]); confidence >= d.minConfidence {
+				grid[row][col] = &tile{bounds: cell, class: class, confidence: confidence}
+			}
+		}
+	}
+
+	// Merge 4-connected tiles of the same class, flood filling from each
+	// tile not yet part of an item.
+	var detections []Detection
+	visited := make(map[*tile]bool)
+	for row := range grid {
+		for col, start := range grid[row] {
+			if start == nil || visited[start] {
+				continue
+			}
+			box := start.bounds
+			var confidence float64
+			area, count := 0, 0
+			queue := [][2]int{{row, col}}
+			visited[start] = true
+			for len(queue) > 0 {
+				r, c := queue[0][0], queue[0][1]
+				queue = queue[1:]
+				current := grid[r][c]
+				box = box.Union(current.bounds)
+				confidence += current.confidence
+				area += current.bounds.Dx() * current.bounds.Dy()
+				count++
+				for _, next := range [][2]int{{r - 1, c}, {r + 1, c}, {r, c - 1}, {r, c + 1}} {
+					if next[0] < 0 || next[0] >= rows || next[1] < 0 || next[1] >= cols {
+						continue
+					}
+					if neighbour := grid[next[0]][next[1]]; neighbour != nil && !visited[neighbour] && neighbour.class == start.class {
+						visited[neighbour] = true
+						queue = append(queue, next)
+					}
+				}
+			}
+
+			filtered := filterClassName(d.classes, func(i int) bool { return i == start.class })
+			if len(filtered) == 0 {
+				return nil, fmt.Errorf("class not found")
+			}
+			detections = append(detections, Detection{
+				Box:        BoundingBox{X: box.Min.X - bounds.Min.X, Y: box.Min.Y - bounds.Min.Y, Width: box.Dx(), Height: box.Dy()},
+				Class:      filtered[0],
+				Group:      d.groups[start.class],
+				Confidence: confidence / float64(count),
+				Area:       area,
+			})
+		}
+	}
+	return detections, nil
+}
+
+// cropImage returns the part of img within r.
+func cropImage(img image.Image, r image.Rectangle) image.Image {
+	if sub, ok := img.(interface {
+		SubImage(r image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(r)
+	}
+	cropped := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(cropped, cropped.Bounds(), img, r.Min, draw.Src)
+	return cropped
+}
+
+// summarizeComposition computes the share of the image's area each detected
+// class and group covers.
+func summarizeComposition(detections []Detection, imageArea int) Composition {
+	classes := make(map[string]int)
+	groups := make(map[string]int)
+	covered := 0
+	for _, detection := range detections {
+		area := detection.coveredArea()
+		classes[detection.Class.Name] += area
+		if detection.Group != "" {
+			groups[detection.Group] += area
+		}
+		covered += area
+	}
+	percent := func(area int) float64 {
+		return math.Round(float64(area)*1000/float64(imageArea)) / 10
+	}
+	shares := func(areas map[string]int) []CompositionShare {
+		result := make([]CompositionShare, 0, len(areas))
+		for name, area := range areas {
+			result = append(result, CompositionShare{Name: name, Percent: percent(area)})
+		}
+		slices.SortFunc(result, func(a, b CompositionShare) int {
+			if c := cmp.Compare(b.Percent, a.Percent); c != 0 {
+				return c
+			}
+			return cmp.Compare(a.Name, b.Name)
+		})
+		return result
+	}
+	return Composition{
+		Classes:      shares(classes),
+		Groups:       shares(groups),
+		Unclassified: percent(imageArea - covered),
+	}
+}
+
+// newTileDetector builds the default ObjectDetector from the configuration.
+// Groups come from the first configured grouping.
+func (p *PredictionService) newTileDetector() *tileDetector {
+	groups := make(map[int]string)
+	if len(p.Config.ModelGrouping) > 0 {
+		for _, group := range p.Config.ModelGrouping[0].GroupConfig {
+			for _, class := range group.Classes {
+				groups[class.Index] = group.Name
+			}
+		}
+	}
+	return &tileDetector{
+		grid:          p.Config.DetectionGrid,
+		minConfidence: p.Config.DetectionThreshold,
+		classify:      p.probabilitiesWithTimeout,
+		classes:       p.Config.SupportedClasses,
+		groups:        groups,
+	}
+}
+
+// detectDecoded finds the items in a decoded image, or answers from the
+// result cache. sum is the SHA-256 of the image bytes.
+func (p *PredictionService) detectDecoded(img image.Image, sum [sha256.Size]byte, options PredictOptions) (*DetectionResult, bool, error) {
+	key := p.resultKey(sum, PredictModeDetect)
+	if !options.NoCache {
+		if value, ok := p.Cache.get(key); ok {
+			result := value.(DetectionResult)
+			return &result, true, nil
+		}
+	}
+
+	detector := p.Detector
+	if detector == nil {
+		detector = p.newTileDetector()
+	}
+	detections, err := detector.Detect(img)
+	if err != nil {
+		var imageErr *ImageError
+		if !errors.As(err, &imageErr) {
+			err = &ImageError{Code: ImageErrorInference, Err: err}
+		}
+		return nil, false, err
+	}
+	bounds := img.Bounds()
+	result := DetectionResult{
+		Detections:  detections,
+		Composition: summarizeComposition(detections, bounds.Dx()*bounds.Dy()),
+	}
+	if result.Detections == nil {
+		result.Detections = []Detection{}
+	}
+	p.Cache.put(key, result)
+	return &result, false, nil
+}
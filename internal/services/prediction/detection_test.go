@@ -0,0 +1,61 @@
+package prediction
+
+import (
+	"image"
+	"testing"
+
+	"github.com/tonespy/ecosort_be/config"
+)
+
+// uniformDetector returns a tileDetector whose classifier finds class 0 in
+// every tile with full confidence, and records the tensors it was given.
+func uniformDetector(grid int, tensors *[][][][]float32) *tileDetector {
+	return &tileDetector{
+		grid:          grid,
+		minConfidence: 0.5,
+		classify: func(tensorData [][][]float32) ([]float32, error) {
+			*tensors = append(*tensors, tensorData)
+			return []float32{1, 0}, nil
+		},
+		classes: []config.Classes{{Name: "plastic", Index: 0}, {Name: "paper", Index: 1}},
+		groups:  map[int]string{},
+	}
+}
+
+func TestTileDetectorClampsGridToSmallImages(t *testing.T) {
+	var tensors [][][][]float32
+	detector := uniformDetector(3, &tensors)
+
+	detections, err := detector.Detect(image.NewRGBA(image.Rect(0, 0, 2, 5)))
+	if err != nil {
+		t.Fatalf("Detect: %v", err)
+	}
+	if len(tensors) != 6 {
+		t.Fatalf("classified %d tiles, want 2 columns by 3 rows", len(tensors))
+	}
+	for _, tensorData := range tensors {
+		if len(tensorData) == 0 || len(tensorData[0]) == 0 {
+			t.Fatalf("classified an empty tile")
+		}
+	}
+	want := BoundingBox{Width: 2, Height: 5}
+	if len(detections) != 1 || detections[0].Box != want || detections[0].Area != 10 {
+		t.Fatalf("detections = %+v, want one item covering the image", detections)
+	}
+}
+
+func TestSummarizeCompositionUsesBoxWithoutArea(t *testing.T) {
+	detections := []Detection{
+		{Box: BoundingBox{Width: 5, Height: 10}, Class: config.Classes{Name: "plastic"}, Group: "recyclable"},
+		{Box: BoundingBox{Width: 10, Height: 10}, Class: config.Classes{Name: "paper"}, Area: 25},
+	}
+
+	composition := summarizeComposition(detections, 100)
+	if composition.Unclassified != 25 {
+		t.Fatalf("unclassified = %v, want 25", composition.Unclassified)
+	}
+	want := []CompositionShare{{Name: "plastic", Percent: 50}, {Name: "paper", Percent: 25}}
+	if len(composition.Classes) != 2 || composition.Classes[0] != want[0] || composition.Classes[1] != want[1] {
+		t.Fatalf("classes = %+v, want %+v", composition.Classes, want)
+	}
+}
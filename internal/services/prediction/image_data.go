@@ -0,0 +1,53 @@
+package prediction
+
+import (
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"strings"
+)
+
+// decodeImageData decodes a base64 encoded image, given either bare or as a
+// data URI ("data:image/jpeg;base64,..."). A data URI's media type is
+// returned as an extension for the content checks' fallback.
+func decodeImageData(encoded string) ([]byte, string, error) {
+	encoded = strings.TrimSpace(encoded)
+	ext := ""
+	if rest, ok := strings.CutPrefix(encoded, "data:"); ok {
+		meta, payload, found := strings.Cut(rest, ",")
+		if !found || !strings.HasSuffix(meta, ";base64") {
+			return nil, "", fmt.Errorf("data URI must be base64 encoded")
+		}
+		if exts, _ := mime.ExtensionsByType(strings.TrimSuffix(meta, ";base64")); len(exts) > 0 {
+			ext = exts[0]
+		}
+		encoded = payload
+	}
+
+	// Accept line-wrapped, padded and unpadded input in both the standard and
+	// URL alphabets.
+	encoded = strings.NewReplacer("\n", "", "\r", "").Replace(encoded)
+	encoded = strings.TrimRight(encoded, "=")
+	data, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		data, err = base64.RawURLEncoding.DecodeString(encoded)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid base64 image: %v", err)
+	}
+	return data, ext, nil
+}
+
+// PredictImageData classifies a base64 encoded image, applying the same
+// content checks and preprocessing as uploaded files. filename is optional
+// and only used when the type can't be sniffed from the content.
+func (p *PredictionService) PredictImageData(encoded string, filename string, options PredictOptions) (PredictResult, error) {
+	data, ext, err := decodeImageData(encoded)
+	if err != nil {
+		return PredictResult{}, &ImageError{Code: ImageErrorDecodeFailed, Err: err}
+	}
+	if filename == "" {
+		filename = "image" + ext
+	}
+	return p.PredictBytes(data, filename, options)
+}
@@ -0,0 +1,176 @@
+package prediction
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/tonespy/ecosort_be/config"
+)
+
+// Error codes reported for images that could not be classified.
+const (
+	ImageErrorDecodeFailed      = "decode_failed"
+	ImageErrorUnsupportedFormat = "unsupported_format"
+	ImageErrorInference         = "inference_error"
+	ImageErrorTimeout           = "timeout"
+	ImageErrorFetchFailed       = "fetch_failed"
+	ImageErrorPoorQuality       = "poor_quality"
+)
+
+// ImageError is a classified failure to process a single image.
+type ImageError struct {
+	Code string
+	Err  error
+}
+
+func (e *ImageError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Code, e.Err)
+}
+
+func (e *ImageError) Unwrap() error {
+	return e.Err
+}
+
+// Transient reports whether retrying the image may succeed.
+func (e *ImageError) Transient() bool {
+	return e.Code == ImageErrorInference
+}
+
+// imageErrorCode returns the error code of err, treating unclassified errors
+// as inference errors.
+func imageErrorCode(err error) string {
+	var imageErr *ImageError
+	if errors.As(err, &imageErr) {
+		return imageErr.Code
+	}
+	return ImageErrorInference
+}
+
+// predictWithTimeout classifies a preprocessed image, giving up after the
+// configured inference timeout.
+func (p *PredictionService) predictWithTimeout(tensorData [][][]float32) (*config.Classes, error) {
+	probabilities, err := p.probabilitiesWithTimeout(tensorData)
+	if err != nil {
+		return nil, err
+	}
+	class, err := p.predictedClass(probabilities)
+	if err != nil {
+		return nil, &ImageError{Code: ImageErrorInference, Err: err}
+	}
+	return class, nil
+}
+
+// probabilitiesWithTimeout runs the model on an image tensor but gives up
+// waiting after the configured inference timeout. The timeout starts once the
+// session is acquired, so time queued behind other inferences doesn't count
+// against it. The session call itself can't be interrupted, so a timed out
+// run still finishes in the background.
+func (p *PredictionService) probabilitiesWithTimeout(tensorData [][][]float32) ([]float32, error) {
+	type result struct {
+		probabilities []float32
+		err           error
+	}
+	started := make(chan struct{})
+	done := make(chan result, 1)
+	go func() {
+		p.sessionMutex.Lock()
+		defer p.sessionMutex.Unlock()
+		close(started)
+		probabilities, err := p.runModel(tensorData)
+		done <- result{probabilities, err}
+	}()
+	<-started
+
+	timer := time.NewTimer(p.Config.InferenceTimeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		if r.err != nil {
+			return nil, &ImageError{Code: ImageErrorInference, Err: r.err}
+		}
+		return r.probabilities, nil
+	case <-timer.C:
+		return nil, &ImageError{Code: ImageErrorTimeout, Err: fmt.Errorf("inference did not finish within %s", p.Config.InferenceTimeout)}
+	}
+}
+
+// jobImageResult is the outcome of classifying one image of a batch job.
+type jobImageResult struct {
+	class    *config.Classes
+	attempts int      // Inference attempts, 0 for cached results
+	cached   bool     // Answered from the result cache
+	hash     uint64   // Perceptual hash, see differenceHash
+	hashed   bool     // The image was decoded and hash is set
+	issues   []string // Quality issues, see checkQuality
+}
+
+// predictJobImage classifies one image of a batch job, retrying transient
+// inference errors with exponential backoff, and removes the image file when
+// done. The result cache is consulted unless noCache is set. Images that
+// already failed before reaching the job, such as unreachable URLs, report
+// their error without any attempt.
+func (p *PredictionService) predictJobImage(ctx context.Context, image jobImage, noCache bool) (jobImageResult, error) {
+	var result jobImageResult
+	if image.err != nil {
+		return result, image.err
+	}
+	defer os.Remove(image.path)
+
+	data, err := os.ReadFile(image.path)
+	if err != nil {
+		return result, &ImageError{Code: ImageErrorDecodeFailed, Err: err}
+	}
+	// Decode even when the result is cached: duplicate detection needs the pixels.
+	img, err := decodeImage(bytes.NewReader(data))
+	if err != nil {
+		return result, err
+	}
+	result.hash, result.hashed = differenceHash(img), true
+	if result.issues, err = p.checkQuality(img); err != nil {
+		return result, err
+	}
+
+	result.class, result.cached, err = p.cachedPredict(p.resultKey(sha256.Sum256(data), PredictModeClassify), noCache, func() (*config.Classes, error) {
+		var class *config.Classes
+		var err error
+		class, result.attempts, err = p.predictWithRetry(ctx, imageTensor(img), image.path)
+		return class, err
+	})
+	return result, err
+}
+
+// predictWithRetry runs inference on a preprocessed image, retrying transient
+// errors with exponential backoff until ctx is done. It returns the number of
+// inference attempts made.
+func (p *PredictionService) predictWithRetry(ctx context.Context, tensorData [][][]float32, imageName string) (*config.Classes, int, error) {
+	backoff := p.Config.ImageRetryBackoff
+	for attempt := 1; ; attempt++ {
+		class, err := p.predictWithTimeout(tensorData)
+		if err == nil {
+			return class, attempt, nil
+		}
+
+		var imageErr *ImageError
+		if !errors.As(err, &imageErr) || !imageErr.Transient() || attempt >= p.Config.ImageRetryAttempts {
+			return nil, attempt, err
+		}
+		p.Logger.Debug("Retrying image inference", map[string]interface{}{
+			"image":   imageName,
+			"attempt": attempt,
+			"backoff": backoff.String(),
+			"error":   err.Error(),
+		})
+
+		select {
+		case <-ctx.Done():
+			return nil, attempt, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,336 @@
+package prediction
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/tonespy/ecosort_be/config"
+)
+
+// maxImageRedirects bounds the redirects followed when fetching an image.
+const maxImageRedirects = 3
+
+// ErrBlockedAddress is returned when an image or callback URL resolves to a
+// private, loopback or otherwise internal address that isn't allowlisted.
+var ErrBlockedAddress = errors.New("address not allowed")
+
+// addressGuard protects outgoing requests to client supplied URLs against
+// server-side request forgery: it only lets them connect to public addresses,
+// checked after DNS resolution, unless a host or range is allowlisted.
+type addressGuard struct {
+	allowedHosts map[string]bool
+	allowedNets  []netip.Prefix
+}
+
+func newAddressGuard(allowlist []string) *addressGuard {
+	g := &addressGuard{allowedHosts: make(map[string]bool)}
+	for _, entry := range allowlist {
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			g.allowedNets = append(g.allowedNets, prefix)
+		} else if addr, err := netip.ParseAddr(entry); err == nil {
+			g.allowedNets = append(g.allowedNets, netip.PrefixFrom(addr, addr.BitLen()))
+		} else {
+			g.allowedHosts[strings.ToLower(entry)] = true
+		}
+	}
+	return g
+}
+
+// transport returns an HTTP transport that only dials addresses the guard allows.
+func (g *addressGuard) transport(timeout time.Duration) *http.Transport {
+	dialer := &net.Dialer{Timeout: timeout}
+	return &http.Transport{
+		Proxy: nil, // A proxy would connect on our behalf and bypass the address checks.
+		DialContext: func(ctx context.Context, network string, address string) (net.Conn, error) {
+			host, port, err := net.SplitHostPort(address)
+			if err != nil {
+				return nil, err
+			}
+			addr, err := g.resolve(ctx, host)
+			if err != nil {
+				return nil, err
+			}
+			// Dial the checked address itself so DNS can't change in between.
+			return dialer.DialContext(ctx, network, net.JoinHostPort(addr.String(), port))
+		},
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+	}
+}
+
+// ImageFetcher downloads images by URL. Connections are restricted by an
+// addressGuard, for the URL and again for every redirect.
+type ImageFetcher struct {
+	client  *http.Client
+	maxSize int64
+	guard   *addressGuard
+}
+
+func NewImageFetcher(cfg *config.Config) *ImageFetcher {
+	f := &ImageFetcher{
+		maxSize: cfg.ImageURLMaxSize,
+		guard:   newAddressGuard(cfg.ImageURLAllowlist),
+	}
+	f.client = &http.Client{
+		Transport: f.guard.transport(cfg.ImageURLTimeout),
+		Timeout:   cfg.ImageURLTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxImageRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxImageRedirects)
+			}
+			return validateImageURL(req.URL)
+		},
+	}
+	return f
+}
+
+// resolve looks up host and returns its first address that may be contacted.
+func (g *addressGuard) resolve(ctx context.Context, host string) (netip.Addr, error) {
+	allowedHost := g.allowedHosts[strings.ToLower(host)]
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if allowedHost || g.allowed(addr) {
+			return addr, nil
+		}
+		return netip.Addr{}, fmt.Errorf("%w: %s", ErrBlockedAddress, host)
+	}
+
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	for _, addr := range addrs {
+		if allowedHost || g.allowed(addr.Unmap()) {
+			return addr.Unmap(), nil
+		}
+	}
+	return netip.Addr{}, fmt.Errorf("%w: %s", ErrBlockedAddress, host)
+}
+
+// allowed reports whether addr is public or in an allowlisted range. NAT64 and
+// 6to4 addresses are judged by the IPv4 address they embed.
+func (g *addressGuard) allowed(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range g.allowedNets {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range specialPurposeNets {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	if embedded, ok := embeddedIPv4(addr); ok {
+		return g.allowed(embedded)
+	}
+	return true
+}
+
+// specialPurposeNets are the special-purpose ranges (RFC 6890 and successors)
+// that IsGlobalUnicast and IsPrivate don't exclude but that don't lead to
+// public hosts.
+var specialPurposeNets = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),       // "This network"
+	netip.MustParsePrefix("100.64.0.0/10"),   // Shared address space (carrier-grade NAT)
+	netip.MustParsePrefix("192.0.0.0/24"),    // IETF protocol assignments
+	netip.MustParsePrefix("192.0.2.0/24"),    // Documentation (TEST-NET-1)
+	netip.MustParsePrefix("192.88.99.0/24"),  // 6to4 relay anycast
+	netip.MustParsePrefix("198.18.0.0/15"),   // Benchmarking
+	netip.MustParsePrefix("198.51.100.0/24"), // Documentation (TEST-NET-2)
+	netip.MustParsePrefix("203.0.113.0/24"),  // Documentation (TEST-NET-3)
+	netip.MustParsePrefix("240.0.0.0/4"),     // Reserved, including broadcast
+	netip.MustParsePrefix("::/96"),           // IPv4-compatible (deprecated)
+	netip.MustParsePrefix("64:ff9b:1::/48"),  // Local-use NAT64
+	netip.MustParsePrefix("100::/64"),        // Discard-only
+	netip.MustParsePrefix("2001::/23"),       // IETF protocol assignments, including Teredo
+	netip.MustParsePrefix("2001:db8::/32"),   // Documentation
+	netip.MustParsePrefix("3fff::/20"),       // Documentation
+	netip.MustParsePrefix("5f00::/16"),       // Segment routing SIDs
+	netip.MustParsePrefix("fec0::/10"),       // Site-local (deprecated)
+}
+
+var (
+	nat64Prefix = netip.MustParsePrefix("64:ff9b::/96")
+	sixToFour   = netip.MustParsePrefix("2002::/16")
+)
+
+// embeddedIPv4 returns the IPv4 address a NAT64 or 6to4 address translates to.
+func embeddedIPv4(addr netip.Addr) (netip.Addr, bool) {
+	raw := addr.As16()
+	switch {
+	case nat64Prefix.Contains(addr):
+		return netip.AddrFrom4([4]byte(raw[12:16])), true
+	case sixToFour.Contains(addr):
+		return netip.AddrFrom4([4]byte(raw[2:6])), true
+	}
+	return netip.Addr{}, false
+}
+
+// validateImageURL checks that an image URL is an absolute http(s) URL.
+func validateImageURL(u *url.URL) error {
+	if u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("image_url must be an absolute http or https URL")
+	}
+	return nil
+}
+
+// ValidateImageURL checks that rawURL can be fetched by an ImageFetcher.
+func ValidateImageURL(rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("image_url must be an absolute http or https URL")
+	}
+	return validateImageURL(parsed)
+}
+
+// Fetch downloads the image at rawURL. The response must fit the size limit
+// and pass the same content checks as uploaded files. Failures are returned
+// as an ImageError.
+func (f *ImageFetcher) Fetch(ctx context.Context, rawURL string) ([]byte, error) {
+	if err := ValidateImageURL(rawURL); err != nil {
+		return nil, &ImageError{Code: ImageErrorFetchFailed, Err: err}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, &ImageError{Code: ImageErrorFetchFailed, Err: err}
+	}
+	req.Header.Set("Accept", "image/*")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, &ImageError{Code: ImageErrorFetchFailed, Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &ImageError{Code: ImageErrorFetchFailed, Err: fmt.Errorf("unexpected status %d", resp.StatusCode)}
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		if !strings.HasPrefix(mediaType, "image/") && mediaType != "application/octet-stream" {
+			return nil, &ImageError{Code: ImageErrorUnsupportedFormat, Err: fmt.Errorf("unsupported content type: %s", contentType)}
+		}
+	}
+	if resp.ContentLength > f.maxSize {
+		return nil, &ImageError{Code: ImageErrorFetchFailed, Err: fmt.Errorf("image exceeds %d bytes", f.maxSize)}
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, f.maxSize+1))
+	if err != nil {
+		return nil, &ImageError{Code: ImageErrorFetchFailed, Err: err}
+	}
+	if int64(len(data)) > f.maxSize {
+		return nil, &ImageError{Code: ImageErrorFetchFailed, Err: fmt.Errorf("image exceeds %d bytes", f.maxSize)}
+	}
+	if err := validateContent(data[:min(len(data), 512)], path.Base(resp.Request.URL.Path), int64(len(data))); err != nil {
+		return nil, &ImageError{Code: ImageErrorUnsupportedFormat, Err: err}
+	}
+	return data, nil
+}
+
+// PredictImageURL downloads and classifies a single image.
+func (p *PredictionService) PredictImageURL(ctx context.Context, imageURL string, options PredictOptions) (PredictResult, error) {
+	data, err := p.Fetcher.Fetch(ctx, imageURL)
+	if err != nil {
+		return PredictResult{}, err
+	}
+	return p.PredictBytes(data, path.Base(imageURL), options)
+}
+
+// maxConcurrentFetches bounds the images of one job downloaded at a time.
+const maxConcurrentFetches = 8
+
+// errFetchDeadline fails the images of a job not fetched within
+// ImageURLJobTimeout.
+var errFetchDeadline = errors.New("job's image fetch time limit exceeded")
+
+// fetchedImage is the outcome of downloading one of a job's images. Images
+// given up on at the deadline were never fetched and hold no slot.
+type fetchedImage struct {
+	data    []byte
+	err     error
+	fetched bool
+}
+
+// FetchJobImages downloads a started job's images from urls in the background
+// and finishes its upload afterwards. Up to maxConcurrentFetches images are
+// downloaded at a time, all within ImageURLJobTimeout, and added to the job in
+// the order given. Images that can't be fetched are reported as failed
+// results instead of failing the whole job.
+func (p *PredictionService) FetchJobImages(upload *JobUpload, urls []string) {
+	jobID := upload.JobID
+	ctx, cancel := context.WithTimeoutCause(upload.ctx, p.Config.ImageURLJobTimeout, errFetchDeadline)
+	results := make([]chan fetchedImage, len(urls))
+	for i := range results {
+		results[i] = make(chan fetchedImage, 1)
+	}
+
+	// A fetch holds its slot until its image is added, so no more than
+	// maxConcurrentFetches downloaded images wait in memory.
+	slots := make(chan struct{}, maxConcurrentFetches)
+	go func() {
+		for i, imageURL := range urls {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				results[i] <- fetchedImage{err: context.Cause(ctx)}
+				continue
+			}
+			go func() {
+				data, err := p.Fetcher.Fetch(ctx, imageURL)
+				if err != nil && ctx.Err() != nil {
+					err = &ImageError{Code: ImageErrorFetchFailed, Err: context.Cause(ctx)}
+				}
+				results[i] <- fetchedImage{data: data, err: err, fetched: true}
+			}()
+		}
+	}()
+
+	go func() {
+		defer cancel()
+		for i, imageURL := range urls {
+			fetched := <-results[i]
+			err := fetched.err
+			if err == nil {
+				_, err = upload.Add(imageURL, bytes.NewReader(fetched.data))
+			}
+			if fetched.fetched {
+				<-slots
+			}
+			if errors.Is(err, ErrJobFinished) || upload.ctx.Err() != nil {
+				break
+			}
+			if err != nil {
+				p.Logger.Info("Failed to fetch job image", map[string]interface{}{"jobID": jobID, "url": imageURL, "error": err.Error()})
+				var imageErr *ImageError
+				if errors.Is(err, ErrUnsupportedUpload) {
+					err = &ImageError{Code: ImageErrorUnsupportedFormat, Err: err}
+				} else if !errors.As(err, &imageErr) {
+					err = &ImageError{Code: ImageErrorFetchFailed, Err: err}
+				}
+				if upload.addFailed(imageURL, err) != nil {
+					break
+				}
+			}
+		}
+		// The client already has the job ID, so even a cancelled job keeps
+		// its record; the worker reports whatever wasn't processed.
+		if _, err := p.FinishUpload(upload, nil); err != nil {
+			p.Logger.Error("Failed to finish job upload", map[string]interface{}{"jobID": jobID, "error": err.Error()}, err)
+		}
+	}()
+}
@@ -0,0 +1,118 @@
+package prediction
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tonespy/ecosort_be/config"
+)
+
+func TestAddressGuardAllowed(t *testing.T) {
+	guard := newAddressGuard(nil)
+	for addr, want := range map[string]bool{
+		"93.184.216.34":        true,
+		"2606:4700:4700::1111": true,
+		"64:ff9b::808:808":     true, // NAT64 of 8.8.8.8
+		"2002:808:808::1":      true, // 6to4 of 8.8.8.8
+		"127.0.0.1":            false,
+		"10.0.0.1":             false,
+		"169.254.169.254":      false,
+		"100.64.0.1":           false,
+		"0.0.0.1":              false,
+		"192.0.0.8":            false,
+		"198.18.0.1":           false,
+		"203.0.113.7":          false,
+		"240.0.0.1":            false,
+		"255.255.255.255":      false,
+		"::1":                  false,
+		"::ffff:127.0.0.1":     false,
+		"::7f00:1":             false,
+		"fe80::1":              false,
+		"fc00::1":              false,
+		"fec0::1":              false,
+		"64:ff9b::a00:1":       false, // NAT64 of 10.0.0.1
+		"64:ff9b::7f00:1":      false, // NAT64 of 127.0.0.1
+		"64:ff9b:1::808:808":   false,
+		"2002:a9fe:a9fe::1":    false, // 6to4 of 169.254.169.254
+		"2001:0:4136:e378::1":  false, // Teredo
+		"2001:db8::1":          false,
+		"ff02::1":              false,
+	} {
+		if got := guard.allowed(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("allowed(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestAddressGuardAllowlist(t *testing.T) {
+	guard := newAddressGuard([]string{"10.0.0.0/8", "198.18.0.1"})
+	for addr, want := range map[string]bool{
+		"10.1.2.3":       true,
+		"64:ff9b::a00:1": true,
+		"198.18.0.1":     true,
+		"198.18.0.2":     false,
+		"127.0.0.1":      false,
+	} {
+		if got := guard.allowed(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("allowed(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+// imageServer serves a small JPEG and counts the requests it gets.
+func imageServer(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var body bytes.Buffer
+	if err := jpeg.Encode(&body, image.NewRGBA(image.Rect(0, 0, 32, 32)), nil); err != nil {
+		t.Fatal(err)
+	}
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(body.Bytes())
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func newTestFetcher(allowlist []string) *ImageFetcher {
+	return NewImageFetcher(&config.Config{
+		ImageURLAllowlist: allowlist,
+		ImageURLMaxSize:   1 << 20,
+		ImageURLTimeout:   5 * time.Second,
+	})
+}
+
+func TestImageFetcherRefusesLocalServer(t *testing.T) {
+	server, requests := imageServer(t)
+
+	_, err := newTestFetcher(nil).Fetch(context.Background(), server.URL+"/a.jpg")
+	if !errors.Is(err, ErrBlockedAddress) {
+		t.Fatalf("Fetch error = %v, want %v", err, ErrBlockedAddress)
+	}
+	if requests.Load() != 0 {
+		t.Fatalf("server on loopback got %d requests, want none", requests.Load())
+	}
+}
+
+func TestImageFetcherAllowlistedServer(t *testing.T) {
+	server, requests := imageServer(t)
+
+	data, err := newTestFetcher([]string{"127.0.0.0/8"}).Fetch(context.Background(), server.URL+"/a.jpg")
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if len(data) == 0 || requests.Load() != 1 {
+		t.Fatalf("got %d bytes in %d requests", len(data), requests.Load())
+	}
+}
@@ -0,0 +1,125 @@
+package prediction
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"strings"
+
+	"github.com/nfnt/resize"
+)
+
+// Quality gate modes, see config.QualityConfig.
+const (
+	QualityModeOff    = "off"
+	QualityModeWarn   = "warn"
+	QualityModeReject = "reject"
+)
+
+// Image quality issues found before inference.
+const (
+	QualityTooSmall    = "too_small"
+	QualityTooBlurry   = "too_blurry"
+	QualityTooDark     = "too_dark"
+	QualityTooBright   = "too_bright"
+	QualityLowContrast = "low_contrast"
+)
+
+// qualitySampleSize bounds the longest side of the copy of an image the
+// sharpness and exposure are measured on, keeping the gate cheap and its
+// thresholds independent of the camera's resolution.
+const qualitySampleSize = 512
+
+// QualityError rejects an image whose quality is too low to classify.
+type QualityError struct {
+	Issues []string
+}
+
+func (e *QualityError) Error() string {
+	return "image quality too low: " + strings.Join(e.Issues, ", ")
+}
+
+// imageQuality holds the measurements the quality gate checks.
+type imageQuality struct {
+	width      int
+	height     int
+	sharpness  float64 // Variance of the Laplacian of the luminance
+	brightness float64 // Mean luminance, 0 to 255
+	contrast   float64 // Standard deviation of the luminance
+}
+
+// measureQuality measures the resolution, sharpness and exposure of img.
+func measureQuality(img image.Image) imageQuality {
+	bounds := img.Bounds()
+	quality := imageQuality{width: bounds.Dx(), height: bounds.Dy()}
+
+	sample := resize.Thumbnail(qualitySampleSize, qualitySampleSize, img, resize.Bilinear)
+	sampleBounds := sample.Bounds()
+	width, height := sampleBounds.Dx(), sampleBounds.Dy()
+	if width < 3 || height < 3 {
+		return quality
+	}
+	gray := make([][]float64, height)
+	var sum, sumSquares float64
+	for y := 0; y < height; y++ {
+		gray[y] = make([]float64, width)
+		for x := 0; x < width; x++ {
+			value := float64(color.GrayModel.Convert(sample.At(sampleBounds.Min.X+x, sampleBounds.Min.Y+y)).(color.Gray).Y)
+			gray[y][x] = value
+			sum += value
+			sumSquares += value * value
+		}
+	}
+	pixels := float64(width * height)
+	quality.brightness = sum / pixels
+	quality.contrast = math.Sqrt(max(sumSquares/pixels-quality.brightness*quality.brightness, 0))
+
+	// A sharp image has strong edges, so its Laplacian varies a lot.
+	var lapSum, lapSquares float64
+	for y := 1; y < height-1; y++ {
+		for x := 1; x < width-1; x++ {
+			laplacian := gray[y-1][x] + gray[y+1][x] + gray[y][x-1] + gray[y][x+1] - 4*gray[y][x]
+			lapSum += laplacian
+			lapSquares += laplacian * laplacian
+		}
+	}
+	interior := float64((width - 2) * (height - 2))
+	mean := lapSum / interior
+	quality.sharpness = lapSquares/interior - mean*mean
+	return quality
+}
+
+// checkQuality runs the quality gate on a decoded image and returns the issues
+// found. In reject mode an image with issues fails with a poor_quality
+// ImageError wrapping a QualityError; in warn mode the issues are only
+// reported alongside the prediction.
+func (p *PredictionService) checkQuality(img image.Image) ([]string, error) {
+	thresholds := p.Config.Quality
+	if thresholds.Mode == QualityModeOff || thresholds.Mode == "" {
+		return nil, nil
+	}
+
+	quality := measureQuality(img)
+	var issues []string
+	if min(quality.width, quality.height) < thresholds.MinSide {
+		// Too small to measure anything else reliably.
+		issues = append(issues, QualityTooSmall)
+	} else {
+		if quality.sharpness < thresholds.MinSharpness {
+			issues = append(issues, QualityTooBlurry)
+		}
+		if quality.brightness < thresholds.MinBrightness {
+			issues = append(issues, QualityTooDark)
+		} else if quality.brightness > thresholds.MaxBrightness {
+			issues = append(issues, QualityTooBright)
+		}
+		if quality.contrast < thresholds.MinContrast {
+			issues = append(issues, QualityLowContrast)
+		}
+	}
+
+	if len(issues) > 0 && thresholds.Mode == QualityModeReject {
+		return issues, &ImageError{Code: ImageErrorPoorQuality, Err: &QualityError{Issues: issues}}
+	}
+	return issues, nil
+}
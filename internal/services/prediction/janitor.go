@@ -0,0 +1,213 @@
+package prediction
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// jobsRootDir holds one upload directory per batch job.
+const jobsRootDir = "wsjobs"
+
+// JobDir returns the upload directory of a batch job.
+func JobDir(jobID string) string {
+	return filepath.Join(jobsRootDir, jobID)
+}
+
+// JanitorReport summarizes what a janitor sweep removed.
+type JanitorReport struct {
+	ExpiredJobs     int   `json:"expiredJobs"`
+	RemovedJobDirs  int   `json:"removedJobDirs"`
+	ExpiredUploads  int   `json:"expiredUploads"`
+	RemovedTempDirs int   `json:"removedTempDirs"`
+	ReclaimedBytes  int64 `json:"reclaimedBytes"`
+}
+
+// StartJanitor periodically expires finished jobs and sweeps leftover upload
+// directories until Shutdown is called.
+func (p *PredictionService) StartJanitor() {
+	stop := make(chan struct{})
+	p.janitorStop = stop
+	go func() {
+		ticker := time.NewTicker(p.Config.JanitorInterval)
+		defer ticker.Stop()
+		for {
+			p.RunJanitor()
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// stopJanitor stops the janitor started by StartJanitor, if any.
+func (p *PredictionService) stopJanitor() {
+	if p.janitorStop != nil {
+		close(p.janitorStop)
+		p.janitorStop = nil
+	}
+}
+
+// RunJanitor performs a single sweep:
+//   - finished jobs older than the retention period are deleted with their directory,
+//   - job directories of finished jobs, or of jobs that no longer exist, are removed,
+//   - resumable uploads idle for longer than the session TTL are removed,
+//   - tmp* directories left in RootDir by single-image predictions are removed
+//     once older than the temp max age.
+func (p *PredictionService) RunJanitor() JanitorReport {
+	var report JanitorReport
+	now := time.Now()
+
+	jobs, err := p.JobStore.List()
+	if err != nil {
+		p.Logger.Error("Janitor failed to list jobs", map[string]interface{}{"error": err.Error()}, err)
+		return report
+	}
+
+	known := make(map[string]*Job, len(jobs))
+	expired := make(map[string]bool)
+	for _, job := range jobs {
+		if job.IsTerminal() && job.FinishedAt != nil && now.Sub(*job.FinishedAt) > p.Config.JobRetention {
+			if err := p.JobStore.Delete(job.JobID); err != nil {
+				p.Logger.Error("Janitor failed to delete job", map[string]interface{}{"jobID": job.JobID, "error": err.Error()}, err)
+				continue
+			}
+			expired[job.JobID] = true
+			report.ExpiredJobs++
+			continue
+		}
+		known[job.JobID] = job
+	}
+
+	// Event logs are kept as long as their job. Jobs created since the listing
+	// are missing from it, so logs of unknown jobs are only dropped once
+	// finished, e.g. of jobs deleted after a failed upload.
+	if p.Events != nil {
+		p.Events.Retain(func(jobID string, finished bool) bool {
+			_, ok := known[jobID]
+			return !expired[jobID] && (ok || !finished)
+		})
+	}
+
+	// Job directories are only needed while a job is queued or running. Unknown
+	// directories get a grace period since a job directory is created just before its record.
+	entries, err := os.ReadDir(jobsRootDir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		p.Logger.Error("Janitor failed to read jobs directory", map[string]interface{}{"error": err.Error()}, err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		job, ok := known[entry.Name()]
+		if ok && !job.IsTerminal() {
+			continue
+		}
+		if !ok && !olderThan(entry, now, p.Config.TempFileMaxAge) {
+			continue
+		}
+		if reclaimed, ok := p.removeDir(filepath.Join(jobsRootDir, entry.Name())); ok {
+			report.RemovedJobDirs++
+			report.ReclaimedBytes += reclaimed
+		}
+	}
+
+	// Resumable uploads are kept until they have been idle for the session TTL;
+	// unreadable ones get the temp max age.
+	entries, err = os.ReadDir(uploadsRootDir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		p.Logger.Error("Janitor failed to read uploads directory", map[string]interface{}{"error": err.Error()}, err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		session, err := readUploadManifest(entry.Name())
+		if err == nil && now.Sub(session.UpdatedAt) <= p.Config.UploadSessionTTL {
+			continue
+		}
+		if err != nil && !olderThan(entry, now, p.Config.TempFileMaxAge) {
+			continue
+		}
+		if reclaimed, ok := p.removeDir(uploadDir(entry.Name())); ok {
+			p.uploadLocks.Delete(entry.Name())
+			report.ExpiredUploads++
+			report.ReclaimedBytes += reclaimed
+		}
+	}
+
+	// Temporary directories that single-image predictions used to leave behind.
+	entries, err = os.ReadDir(p.Config.RootDir)
+	if err != nil {
+		p.Logger.Error("Janitor failed to read root directory", map[string]interface{}{"error": err.Error()}, err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !isTempDirName(entry.Name()) || !olderThan(entry, now, p.Config.TempFileMaxAge) {
+			continue
+		}
+		if reclaimed, ok := p.removeDir(filepath.Join(p.Config.RootDir, entry.Name())); ok {
+			report.RemovedTempDirs++
+			report.ReclaimedBytes += reclaimed
+		}
+	}
+
+	p.Logger.Info("Janitor sweep completed", map[string]interface{}{
+		"expiredJobs":     report.ExpiredJobs,
+		"removedJobDirs":  report.RemovedJobDirs,
+		"expiredUploads":  report.ExpiredUploads,
+		"removedTempDirs": report.RemovedTempDirs,
+		"reclaimedBytes":  report.ReclaimedBytes,
+	})
+	return report
+}
+
+// removeDir deletes a directory tree and returns the bytes it held.
+func (p *PredictionService) removeDir(path string) (int64, bool) {
+	size := dirSize(path)
+	if err := os.RemoveAll(path); err != nil {
+		p.Logger.Error("Janitor failed to remove directory", map[string]interface{}{"path": path, "error": err.Error()}, err)
+		return 0, false
+	}
+	return size, true
+}
+
+// dirSize returns the total size of the regular files under path.
+func dirSize(path string) int64 {
+	var size int64
+	filepath.WalkDir(path, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// olderThan reports whether a directory entry was last modified more than age ago.
+func olderThan(entry fs.DirEntry, now time.Time, age time.Duration) bool {
+	info, err := entry.Info()
+	return err == nil && now.Sub(info.ModTime()) > age
+}
+
+// isTempDirName matches the names os.MkdirTemp generates for the "tmp" pattern,
+// e.g. "tmp123456789", while leaving the model directory "tmp" alone.
+func isTempDirName(name string) bool {
+	suffix, ok := strings.CutPrefix(name, "tmp")
+	if !ok || suffix == "" {
+		return false
+	}
+	for _, r := range suffix {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,156 @@
+package prediction
+
+import (
+	"sync"
+)
+
+// Job event types published while a job is processed.
+const (
+	JobEventProgress = "progress"
+	// JobEventSnapshot carries the stored job when its event log is gone,
+	// e.g. after a restart. It is never published and has sequence 0.
+	JobEventSnapshot = "snapshot"
+)
+
+// subscriberBuffer is how many events a subscriber may fall behind before it
+// is dropped. Dropped subscribers can resume from their last sequence number.
+const subscriberBuffer = 64
+
+// JobEvent is a sequence-numbered update published for a job. Data is the
+// payload sent to websocket clients for the same update.
+type JobEvent struct {
+	Sequence int64  `json:"sequence"`
+	JobID    string `json:"jobID"`
+	Type     string `json:"type"`
+	Data     any    `json:"data"`
+}
+
+// Terminal reports whether the event ends the job's stream.
+func (e JobEvent) Terminal() bool {
+	return e.Type != JobEventProgress && e.Type != JobEventSnapshot
+}
+
+// EventSubscription receives a job's events as they are published. C is
+// closed after the terminal event, or early if the subscriber falls behind.
+type EventSubscription struct {
+	C      <-chan JobEvent
+	events chan JobEvent
+	bus    *JobEventBus
+	jobID  string
+}
+
+// Unsubscribe stops delivery to the subscription.
+func (s *EventSubscription) Unsubscribe() {
+	s.bus.unsubscribe(s)
+}
+
+type jobEventLog struct {
+	events      []JobEvent
+	subscribers map[*EventSubscription]struct{}
+	finished    bool
+}
+
+// JobEventBus keeps an in-memory, sequence-numbered log of every job's events
+// and fans them out to subscribers, so clients can resume from the last event
+// they saw.
+type JobEventBus struct {
+	mu   sync.Mutex
+	logs map[string]*jobEventLog
+}
+
+func NewJobEventBus() *JobEventBus {
+	return &JobEventBus{logs: make(map[string]*jobEventLog)}
+}
+
+func (b *JobEventBus) log(jobID string) *jobEventLog {
+	log, ok := b.logs[jobID]
+	if !ok {
+		log = &jobEventLog{subscribers: make(map[*EventSubscription]struct{})}
+		b.logs[jobID] = log
+	}
+	return log
+}
+
+// Publish appends an event to the job's log and delivers it to subscribers.
+func (b *JobEventBus) Publish(jobID string, eventType string, data any) JobEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	log := b.log(jobID)
+	event := JobEvent{
+		Sequence: int64(len(log.events)) + 1,
+		JobID:    jobID,
+		Type:     eventType,
+		Data:     data,
+	}
+	log.events = append(log.events, event)
+
+	for sub := range log.subscribers {
+		select {
+		case sub.events <- event:
+		default:
+			// Slow subscriber; drop it so it can't hold up the job.
+			delete(log.subscribers, sub)
+			close(sub.events)
+			continue
+		}
+		if event.Terminal() {
+			delete(log.subscribers, sub)
+			close(sub.events)
+		}
+	}
+	if event.Terminal() {
+		log.finished = true
+	}
+	return event
+}
+
+// Subscribe returns the events published after sequence `after` and, unless
+// the job's stream already finished, a subscription for the ones that follow.
+// known is false when no events were ever published for the job.
+func (b *JobEventBus) Subscribe(jobID string, after int64) (backlog []JobEvent, sub *EventSubscription, known bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	log, ok := b.logs[jobID]
+	if ok && after < int64(len(log.events)) {
+		backlog = append(backlog, log.events[max(after, 0):]...)
+	}
+	if ok && log.finished {
+		return backlog, nil, true
+	}
+
+	log = b.log(jobID)
+	events := make(chan JobEvent, subscriberBuffer)
+	sub = &EventSubscription{C: events, events: events, bus: b, jobID: jobID}
+	log.subscribers[sub] = struct{}{}
+	return backlog, sub, ok
+}
+
+func (b *JobEventBus) unsubscribe(sub *EventSubscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	log, ok := b.logs[sub.jobID]
+	if !ok {
+		return
+	}
+	if _, ok := log.subscribers[sub]; ok {
+		delete(log.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// Retain drops the logs of jobs for which keep returns false, closing any
+// remaining subscriptions. finished tells keep whether the job's terminal
+// event was published.
+func (b *JobEventBus) Retain(keep func(jobID string, finished bool) bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for jobID, log := range b.logs {
+		if keep(jobID, log.finished) {
+			continue
+		}
+		for sub := range log.subscribers {
+			close(sub.events)
+		}
+		delete(b.logs, jobID)
+	}
+}
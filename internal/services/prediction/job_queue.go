@@ -0,0 +1,325 @@
+package prediction
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull is returned when a job is submitted while the queue is at capacity.
+var ErrQueueFull = errors.New("job queue is full")
+
+// Scheduling classes for batch jobs. Higher classes are dispatched first.
+const (
+	JobPriorityLow    = "low"
+	JobPriorityNormal = "normal"
+	JobPriorityHigh   = "high"
+)
+
+// jobPriorityLevels orders the scheduling classes from lowest to highest.
+var jobPriorityLevels = []string{JobPriorityLow, JobPriorityNormal, JobPriorityHigh}
+
+// ParseJobPriority validates a priority name, defaulting to normal when empty.
+func ParseJobPriority(priority string) (string, error) {
+	if priority == "" {
+		return JobPriorityNormal, nil
+	}
+	for _, level := range jobPriorityLevels {
+		if priority == level {
+			return priority, nil
+		}
+	}
+	return "", fmt.Errorf("invalid priority %q, expected one of low, normal, high", priority)
+}
+
+// priorityLevel returns the numeric level of a scheduling class.
+func priorityLevel(priority string) int {
+	for level, name := range jobPriorityLevels {
+		if name == priority {
+			return level
+		}
+	}
+	return 1
+}
+
+// queuedJob is a submitted job waiting for a worker. A started job whose
+// worker ran out of uploaded images waits in the queue again, keeping its
+// progress in processed and duplicates.
+type queuedJob struct {
+	ctx        context.Context
+	jobID      string
+	clientID   string
+	priority   int
+	enqueuedAt time.Time
+	upload     *JobUpload
+	running    *runningJob
+	started    bool
+	processed  int
+	duplicates *duplicateIndex
+}
+
+// ready reports whether a worker has something to do for the job: images it
+// hasn't processed, a completed upload to finish or a cancellation to record.
+func (j *queuedJob) ready() bool {
+	return j.ctx.Err() != nil || j.upload.ready(j.processed)
+}
+
+// queueSlot describes where a queued job stands in the dispatch order.
+type queueSlot struct {
+	position    int
+	imagesAhead int
+}
+
+// fairLane holds the jobs of one scheduling class and hands them out
+// round-robin across clients, so one client's large backlog can't starve
+// everyone else in the same class.
+type fairLane struct {
+	clients []string
+	pending map[string][]*queuedJob
+}
+
+func newFairLane() *fairLane {
+	return &fairLane{pending: make(map[string][]*queuedJob)}
+}
+
+func (l *fairLane) push(job *queuedJob) {
+	if len(l.pending[job.clientID]) == 0 {
+		l.clients = append(l.clients, job.clientID)
+	}
+	l.pending[job.clientID] = append(l.pending[job.clientID], job)
+}
+
+// next returns the job to dispatch next: taking clients in turn, their first
+// job that is ready. A nil ready treats every job as ready.
+func (l *fairLane) next(ready func(*queuedJob) bool) *queuedJob {
+	for _, clientID := range l.clients {
+		for _, job := range l.pending[clientID] {
+			if ready == nil || ready(job) {
+				return job
+			}
+		}
+	}
+	return nil
+}
+
+// take removes a job returned by next, moving its client to the back of the turn order.
+func (l *fairLane) take(job *queuedJob) {
+	l.remove(job.jobID)
+	if len(l.pending[job.clientID]) > 0 {
+		i := slices.Index(l.clients, job.clientID)
+		l.clients = append(append(l.clients[:i:i], l.clients[i+1:]...), job.clientID)
+	}
+}
+
+func (l *fairLane) remove(jobID string) (*queuedJob, bool) {
+	for clientID, jobs := range l.pending {
+		for i, job := range jobs {
+			if job.jobID != jobID {
+				continue
+			}
+			jobs = append(jobs[:i:i], jobs[i+1:]...)
+			if len(jobs) == 0 {
+				delete(l.pending, clientID)
+				for j, id := range l.clients {
+					if id == clientID {
+						l.clients = append(l.clients[:j:j], l.clients[j+1:]...)
+						break
+					}
+				}
+			} else {
+				l.pending[clientID] = jobs
+			}
+			return job, true
+		}
+	}
+	return nil, false
+}
+
+// clone copies the lane so dispatch can be simulated without mutating it.
+func (l *fairLane) clone() *fairLane {
+	copied := &fairLane{
+		clients: append([]string(nil), l.clients...),
+		pending: make(map[string][]*queuedJob, len(l.pending)),
+	}
+	for clientID, jobs := range l.pending {
+		copied.pending[clientID] = append([]*queuedJob(nil), jobs...)
+	}
+	return copied
+}
+
+// jobQueue is a bounded priority queue of batch jobs. Higher scheduling
+// classes go first, but a waiting job gains one priority level for every
+// aging interval it has waited, so low priority jobs can't starve. Only ready
+// jobs are dispatched. Started jobs waiting for images don't count against
+// the capacity.
+type jobQueue struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	lanes    []*fairLane
+	size     int
+	started  int // Jobs in the queue a worker already started
+	capacity int
+	aging    time.Duration
+	closed   bool
+}
+
+func newJobQueue(capacity int, aging time.Duration) *jobQueue {
+	q := &jobQueue{
+		capacity: capacity,
+		aging:    aging,
+	}
+	for range jobPriorityLevels {
+		q.lanes = append(q.lanes, newFairLane())
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// push adds a job to its scheduling class.
+func (q *jobQueue) push(job *queuedJob) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrShuttingDown
+	}
+	if q.size-q.started >= q.capacity {
+		return ErrQueueFull
+	}
+	q.lanes[job.priority].push(job)
+	q.size++
+	q.cond.Signal()
+	return nil
+}
+
+// requeue puts back a started job whose worker ran out of uploaded images, to
+// be dispatched again once more arrive.
+func (q *jobQueue) requeue(job *queuedJob) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.lanes[job.priority].push(job)
+	q.size++
+	q.started++
+	q.cond.Signal()
+}
+
+// wake makes waiting workers check again for ready jobs.
+func (q *jobQueue) wake() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// pop blocks until a job is ready and returns the next job to run.
+// It returns false once the queue is closed.
+func (q *jobQueue) pop() (*queuedJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for !q.closed {
+		if lane, job := q.next(q.lanes, time.Now(), (*queuedJob).ready); job != nil {
+			lane.take(job)
+			q.taken(job)
+			return job, true
+		}
+		q.cond.Wait()
+	}
+	return nil, false
+}
+
+// next picks the job with the highest effective priority among the lanes'
+// next jobs, and its lane. Ties go to the higher scheduling class, then to
+// the job that waited longest.
+func (q *jobQueue) next(lanes []*fairLane, now time.Time, ready func(*queuedJob) bool) (*fairLane, *queuedJob) {
+	var best *fairLane
+	var bestJob *queuedJob
+	bestScore := -1
+	for level := len(lanes) - 1; level >= 0; level-- {
+		head := lanes[level].next(ready)
+		if head == nil {
+			continue
+		}
+		score := level
+		if q.aging > 0 {
+			score += int(now.Sub(head.enqueuedAt) / q.aging)
+		}
+		if score > bestScore || (score == bestScore && head.enqueuedAt.Before(bestJob.enqueuedAt)) {
+			best, bestJob, bestScore = lanes[level], head, score
+		}
+	}
+	return best, bestJob
+}
+
+// taken updates the counts for a job leaving the queue. The caller must hold q.mu.
+func (q *jobQueue) taken(job *queuedJob) {
+	q.size--
+	if job.started {
+		q.started--
+	}
+}
+
+// remove takes a specific job out of the queue.
+func (q *jobQueue) remove(jobID string) (*queuedJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, lane := range q.lanes {
+		if job, ok := lane.remove(jobID); ok {
+			q.taken(job)
+			return job, true
+		}
+	}
+	return nil, false
+}
+
+// removeAll empties the queue and returns the jobs that were waiting.
+func (q *jobQueue) removeAll() []*queuedJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var removed []*queuedJob
+	for i, lane := range q.lanes {
+		for job := lane.next(nil); job != nil; job = lane.next(nil) {
+			lane.take(job)
+			removed = append(removed, job)
+		}
+		q.lanes[i] = newFairLane()
+	}
+	q.size = 0
+	q.started = 0
+	return removed
+}
+
+// slots simulates dispatch from the current state and returns every queued
+// job's position along with the number of images queued ahead of it. Started
+// jobs are left out; their images count as running.
+func (q *jobQueue) slots() map[string]queueSlot {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	lanes := make([]*fairLane, len(q.lanes))
+	for i, lane := range q.lanes {
+		lanes[i] = lane.clone()
+	}
+
+	now := time.Now()
+	slots := make(map[string]queueSlot, q.size)
+	position, imagesAhead := 0, 0
+	for lane, job := q.next(lanes, now, nil); job != nil; lane, job = q.next(lanes, now, nil) {
+		lane.take(job)
+		if job.started {
+			continue
+		}
+		position++
+		slots[job.jobID] = queueSlot{position: position, imagesAhead: imagesAhead}
+		images, _ := job.upload.count()
+		imagesAhead += images
+	}
+	return slots
+}
+
+// close wakes all waiting workers and makes pop return false.
+func (q *jobQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
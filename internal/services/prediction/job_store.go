@@ -0,0 +1,161 @@
+package prediction
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/tonespy/ecosort_be/config"
+)
+
+// Job statuses. A job moves from queued to running and ends in one of the
+// terminal statuses.
+const (
+	JobStatusQueued    = "queued"
+	JobStatusRunning   = "running"
+	JobStatusCompleted = "completed"
+	JobStatusStopped   = "stopped"
+	JobStatusCancelled = "cancelled"
+)
+
+// ErrJobNotFound is returned by a JobStore when no job exists for an ID.
+var ErrJobNotFound = errors.New("job not found")
+
+// JobStatusChange records a single status transition of a job.
+type JobStatusChange struct {
+	Status string    `json:"status"`
+	At     time.Time `json:"at"`
+}
+
+// Job is the stored record of a batch prediction job: its metadata,
+// per-image results and status history. Counts tallies Predictions per status
+// and ErrorCounts the failed ones per error code, so summaries can be built
+// from the metadata without loading every result.
+type Job struct {
+	JobID       string               `json:"jobID"`
+	ClientID    string               `json:"clientID,omitempty"`
+	Progress    int                  `json:"progress"`
+	Status      string               `json:"status"`
+	Total       int                  `json:"total"`
+	Uploading   bool                 `json:"uploading,omitempty"` // Images are still arriving; Total is not final
+	Priority    string               `json:"priority,omitempty"`
+	CallbackURL string               `json:"callbackURL,omitempty"`
+	Predictions []JobImagePrediction `json:"predictions,omitempty"`
+	Counts      map[string]int       `json:"counts,omitempty"`
+	ErrorCounts map[string]int       `json:"errorCounts,omitempty"`
+	History     []JobStatusChange    `json:"history,omitempty"`
+	CreatedAt   time.Time            `json:"createdAt"`
+	UpdatedAt   time.Time            `json:"updatedAt"`
+	FinishedAt  *time.Time           `json:"finishedAt,omitempty"`
+	Deliveries  []WebhookDelivery    `json:"deliveries,omitempty"`
+
+	// Derived fields, computed when the job is read and never stored.
+	QueuePosition  int         `json:"queuePosition,omitempty"`
+	EstimatedStart *time.Time  `json:"estimatedStart,omitempty"`
+	Results        *JobResults `json:"results,omitempty"`
+}
+
+// JobStore persists batch jobs. Implementations must be safe for concurrent use
+// and must not let callers mutate stored records outside of Update.
+//
+// A job's per-image results and webhook deliveries are stored apart from its
+// metadata, so they grow by appending instead of rewriting the whole record.
+// Only Get returns them.
+type JobStore interface {
+	// Create stores a new job. It fails if a job with the same ID exists.
+	Create(job *Job) error
+	// Get returns a copy of the job, with its results and deliveries, or ErrJobNotFound.
+	Get(jobID string) (*Job, error)
+	// Update atomically applies fn to the stored job's metadata and returns the
+	// updated copy. If fn returns an error nothing is written.
+	Update(jobID string, fn func(job *Job) error) (*Job, error)
+	// AddPredictions atomically appends results to the job, counting them, and
+	// applies fn like Update. fn may be nil.
+	AddPredictions(jobID string, predictions []JobImagePrediction, fn func(job *Job) error) (*Job, error)
+	// AddDelivery appends a webhook delivery attempt to the job's log.
+	AddDelivery(jobID string, delivery WebhookDelivery) error
+	// Delete removes a job. Deleting a missing job is not an error.
+	Delete(jobID string) error
+	// List returns copies of all stored jobs' metadata.
+	List() ([]*Job, error)
+	// Close releases any resources held by the store.
+	Close() error
+}
+
+// NewJobStore builds the job store selected in the configuration.
+func NewJobStore(cfg *config.Config) (JobStore, error) {
+	switch cfg.JobStoreType {
+	case "memory":
+		return NewMemoryJobStore(), nil
+	case "bolt", "":
+		return NewBoltJobStore(cfg.JobStorePath)
+	default:
+		return nil, fmt.Errorf("unknown job store type: %s", cfg.JobStoreType)
+	}
+}
+
+// IsTerminal reports whether the job has finished and will not change further.
+func (j *Job) IsTerminal() bool {
+	return j.Status == JobStatusCompleted || j.Status == JobStatusStopped || j.Status == JobStatusCancelled
+}
+
+// SetStatus moves the job to status, recording the transition when it changes.
+func (j *Job) SetStatus(status string, at time.Time) {
+	if j.Status == status {
+		return
+	}
+	j.Status = status
+	j.History = append(j.History, JobStatusChange{Status: status, At: at})
+	if j.IsTerminal() {
+		finishedAt := at
+		j.FinishedAt = &finishedAt
+	}
+}
+
+// countPredictions adds predictions to the job's Counts and ErrorCounts.
+func (j *Job) countPredictions(predictions []JobImagePrediction) {
+	for _, prediction := range predictions {
+		if j.Counts == nil {
+			j.Counts = make(map[string]int)
+		}
+		j.Counts[prediction.Status]++
+		if prediction.Status == "Failed" {
+			if j.ErrorCounts == nil {
+				j.ErrorCounts = make(map[string]int)
+			}
+			j.ErrorCounts[prediction.ErrorCode]++
+		}
+	}
+}
+
+// metadata returns a deep copy of the job without its results and deliveries.
+func (j *Job) metadata() *Job {
+	stripped := *j
+	stripped.Predictions = nil
+	stripped.Deliveries = nil
+	return stripped.clone()
+}
+
+// clone returns a deep copy of the job so stored records are never shared.
+func (j *Job) clone() *Job {
+	copied := *j
+	copied.Predictions = append([]JobImagePrediction(nil), j.Predictions...)
+	copied.Counts = maps.Clone(j.Counts)
+	copied.ErrorCounts = maps.Clone(j.ErrorCounts)
+	copied.History = append([]JobStatusChange(nil), j.History...)
+	copied.Deliveries = append([]WebhookDelivery(nil), j.Deliveries...)
+	if j.FinishedAt != nil {
+		finishedAt := *j.FinishedAt
+		copied.FinishedAt = &finishedAt
+	}
+	if j.Results != nil {
+		results := *j.Results
+		copied.Results = &results
+	}
+	if j.EstimatedStart != nil {
+		estimatedStart := *j.EstimatedStart
+		copied.EstimatedStart = &estimatedStart
+	}
+	return &copied
+}
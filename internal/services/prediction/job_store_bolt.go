@@ -0,0 +1,289 @@
+package prediction
+
+import (
+	"cmp"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	jobsBucket        = []byte("jobs")
+	predictionsBucket = []byte("predictions")
+	deliveriesBucket  = []byte("deliveries")
+)
+
+// BoltJobStore persists jobs as JSON documents in an embedded BoltDB file so
+// job state survives restarts. The jobs bucket holds each job's metadata; its
+// results and deliveries live in a nested bucket per job under the predictions
+// and deliveries buckets, keyed by image ID and by sequence respectively.
+type BoltJobStore struct {
+	db *bolt.DB
+}
+
+// storedPrediction is a per-image result with its position in the job, since
+// the results bucket is ordered by image ID.
+type storedPrediction struct {
+	Sequence uint64 `json:"sequence"`
+	JobImagePrediction
+}
+
+// NewBoltJobStore opens (or creates) the BoltDB file at path.
+func NewBoltJobStore(path string) (*BoltJobStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create job store directory: %v", err)
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open job store: %v", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{jobsBucket, predictionsBucket, deliveriesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize job store: %v", err)
+	}
+
+	return &BoltJobStore{db: db}, nil
+}
+
+func (s *BoltJobStore) Create(job *Job) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(jobsBucket)
+		if bucket.Get([]byte(job.JobID)) != nil {
+			return fmt.Errorf("job %s already exists", job.JobID)
+		}
+		if err := putResults(tx, job.JobID, job.Predictions); err != nil {
+			return err
+		}
+		for _, delivery := range job.Deliveries {
+			if err := putDelivery(tx, job.JobID, delivery); err != nil {
+				return err
+			}
+		}
+		return putJob(bucket, job.metadata())
+	})
+}
+
+func (s *BoltJobStore) Get(jobID string) (*Job, error) {
+	var job *Job
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		job, err = getJob(tx.Bucket(jobsBucket), jobID)
+		if err != nil {
+			return err
+		}
+		if job.Predictions, err = getResults(tx, jobID); err != nil {
+			return err
+		}
+		job.Deliveries, err = getDeliveries(tx, jobID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+func (s *BoltJobStore) Update(jobID string, fn func(job *Job) error) (*Job, error) {
+	return s.AddPredictions(jobID, nil, fn)
+}
+
+func (s *BoltJobStore) AddPredictions(jobID string, predictions []JobImagePrediction, fn func(job *Job) error) (*Job, error) {
+	var job *Job
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(jobsBucket)
+		var err error
+		job, err = getJob(bucket, jobID)
+		if err != nil {
+			return err
+		}
+		job.countPredictions(predictions)
+		if fn != nil {
+			if err := fn(job); err != nil {
+				return err
+			}
+		}
+		job.UpdatedAt = time.Now()
+		job = job.metadata()
+		if err := putResults(tx, jobID, predictions); err != nil {
+			return err
+		}
+		return putJob(bucket, job)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+func (s *BoltJobStore) AddDelivery(jobID string, delivery WebhookDelivery) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(jobsBucket).Get([]byte(jobID)) == nil {
+			return ErrJobNotFound
+		}
+		return putDelivery(tx, jobID, delivery)
+	})
+}
+
+func (s *BoltJobStore) Delete(jobID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{predictionsBucket, deliveriesBucket} {
+			err := tx.Bucket(name).DeleteBucket([]byte(jobID))
+			if err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+				return err
+			}
+		}
+		return tx.Bucket(jobsBucket).Delete([]byte(jobID))
+	})
+}
+
+func (s *BoltJobStore) List() ([]*Job, error) {
+	var jobs []*Job
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).ForEach(func(_, value []byte) error {
+			var job Job
+			if err := json.Unmarshal(value, &job); err != nil {
+				return err
+			}
+			jobs = append(jobs, &job)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+func (s *BoltJobStore) Close() error {
+	return s.db.Close()
+}
+
+func getJob(bucket *bolt.Bucket, jobID string) (*Job, error) {
+	value := bucket.Get([]byte(jobID))
+	if value == nil {
+		return nil, ErrJobNotFound
+	}
+	var job Job
+	if err := json.Unmarshal(value, &job); err != nil {
+		return nil, fmt.Errorf("failed to decode job %s: %v", jobID, err)
+	}
+	return &job, nil
+}
+
+func putJob(bucket *bolt.Bucket, job *Job) error {
+	value, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to encode job %s: %v", job.JobID, err)
+	}
+	return bucket.Put([]byte(job.JobID), value)
+}
+
+// getResults returns a job's results in the order they were added.
+func getResults(tx *bolt.Tx, jobID string) ([]JobImagePrediction, error) {
+	bucket := tx.Bucket(predictionsBucket).Bucket([]byte(jobID))
+	if bucket == nil {
+		return nil, nil
+	}
+	var stored []storedPrediction
+	err := bucket.ForEach(func(_, value []byte) error {
+		var prediction storedPrediction
+		if err := json.Unmarshal(value, &prediction); err != nil {
+			return fmt.Errorf("failed to decode result of job %s: %v", jobID, err)
+		}
+		stored = append(stored, prediction)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(stored, func(a, b storedPrediction) int {
+		return cmp.Compare(a.Sequence, b.Sequence)
+	})
+	predictions := make([]JobImagePrediction, len(stored))
+	for i, prediction := range stored {
+		predictions[i] = prediction.JobImagePrediction
+	}
+	return predictions, nil
+}
+
+// putResults appends results to a job's results bucket.
+func putResults(tx *bolt.Tx, jobID string, predictions []JobImagePrediction) error {
+	if len(predictions) == 0 {
+		return nil
+	}
+	bucket, err := tx.Bucket(predictionsBucket).CreateBucketIfNotExists([]byte(jobID))
+	if err != nil {
+		return err
+	}
+	for _, prediction := range predictions {
+		sequence, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		value, err := json.Marshal(storedPrediction{Sequence: sequence, JobImagePrediction: prediction})
+		if err != nil {
+			return fmt.Errorf("failed to encode result of job %s: %v", jobID, err)
+		}
+		key := prediction.ImageID
+		if key == "" {
+			key = fmt.Sprintf("#%d", sequence)
+		}
+		if err := bucket.Put([]byte(key), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getDeliveries returns a job's delivery attempts in the order they were made.
+func getDeliveries(tx *bolt.Tx, jobID string) ([]WebhookDelivery, error) {
+	bucket := tx.Bucket(deliveriesBucket).Bucket([]byte(jobID))
+	if bucket == nil {
+		return nil, nil
+	}
+	var deliveries []WebhookDelivery
+	err := bucket.ForEach(func(_, value []byte) error {
+		var delivery WebhookDelivery
+		if err := json.Unmarshal(value, &delivery); err != nil {
+			return fmt.Errorf("failed to decode delivery of job %s: %v", jobID, err)
+		}
+		deliveries = append(deliveries, delivery)
+		return nil
+	})
+	return deliveries, err
+}
+
+// putDelivery appends a delivery attempt to a job's deliveries bucket, keyed by
+// its big-endian sequence number so iteration follows insertion order.
+func putDelivery(tx *bolt.Tx, jobID string, delivery WebhookDelivery) error {
+	bucket, err := tx.Bucket(deliveriesBucket).CreateBucketIfNotExists([]byte(jobID))
+	if err != nil {
+		return err
+	}
+	sequence, err := bucket.NextSequence()
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(delivery)
+	if err != nil {
+		return fmt.Errorf("failed to encode delivery of job %s: %v", jobID, err)
+	}
+	return bucket.Put(binary.BigEndian.AppendUint64(nil, sequence), value)
+}
@@ -0,0 +1,108 @@
+package prediction
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MemoryJobStore keeps jobs in process memory. Jobs are lost on restart.
+type MemoryJobStore struct {
+	sync.RWMutex
+	jobs map[string]*memoryJob
+}
+
+// memoryJob holds a job's metadata apart from its results and deliveries, so
+// updates never copy the growing lists.
+type memoryJob struct {
+	meta        *Job
+	predictions []JobImagePrediction
+	deliveries  []WebhookDelivery
+}
+
+func NewMemoryJobStore() *MemoryJobStore {
+	return &MemoryJobStore{jobs: make(map[string]*memoryJob)}
+}
+
+func (s *MemoryJobStore) Create(job *Job) error {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.jobs[job.JobID]; ok {
+		return fmt.Errorf("job %s already exists", job.JobID)
+	}
+	s.jobs[job.JobID] = &memoryJob{
+		meta:        job.metadata(),
+		predictions: append([]JobImagePrediction(nil), job.Predictions...),
+		deliveries:  append([]WebhookDelivery(nil), job.Deliveries...),
+	}
+	return nil
+}
+
+func (s *MemoryJobStore) Get(jobID string) (*Job, error) {
+	s.RLock()
+	defer s.RUnlock()
+	stored, ok := s.jobs[jobID]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	job := stored.meta.clone()
+	job.Predictions = append([]JobImagePrediction(nil), stored.predictions...)
+	job.Deliveries = append([]WebhookDelivery(nil), stored.deliveries...)
+	return job, nil
+}
+
+func (s *MemoryJobStore) Update(jobID string, fn func(job *Job) error) (*Job, error) {
+	return s.AddPredictions(jobID, nil, fn)
+}
+
+func (s *MemoryJobStore) AddPredictions(jobID string, predictions []JobImagePrediction, fn func(job *Job) error) (*Job, error) {
+	s.Lock()
+	defer s.Unlock()
+	stored, ok := s.jobs[jobID]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	job := stored.meta.clone()
+	job.countPredictions(predictions)
+	if fn != nil {
+		if err := fn(job); err != nil {
+			return nil, err
+		}
+	}
+	job.UpdatedAt = time.Now()
+	stored.meta = job.metadata()
+	stored.predictions = append(stored.predictions, predictions...)
+	return stored.meta.clone(), nil
+}
+
+func (s *MemoryJobStore) AddDelivery(jobID string, delivery WebhookDelivery) error {
+	s.Lock()
+	defer s.Unlock()
+	stored, ok := s.jobs[jobID]
+	if !ok {
+		return ErrJobNotFound
+	}
+	stored.deliveries = append(stored.deliveries, delivery)
+	return nil
+}
+
+func (s *MemoryJobStore) Delete(jobID string) error {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.jobs, jobID)
+	return nil
+}
+
+func (s *MemoryJobStore) List() ([]*Job, error) {
+	s.RLock()
+	defer s.RUnlock()
+	jobs := make([]*Job, 0, len(s.jobs))
+	for _, stored := range s.jobs {
+		jobs = append(jobs, stored.meta.clone())
+	}
+	return jobs, nil
+}
+
+func (s *MemoryJobStore) Close() error {
+	return nil
+}
@@ -0,0 +1,382 @@
+package prediction
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrFileTooLarge is returned when an uploaded file exceeds the per-file limit.
+	ErrFileTooLarge = errors.New("file is too large")
+	// ErrUnsupportedUpload is returned for uploaded files that aren't images.
+	ErrUnsupportedUpload = errors.New("unsupported file type")
+	// ErrUploadFailed is the cancellation cause for jobs whose upload failed.
+	ErrUploadFailed = errors.New("upload failed")
+)
+
+// jobImage is an image of a batch job, spooled to the job directory under
+// its ID. name is the client's file name, kept for display only. err is set
+// for images that failed before they could be stored, which have no file.
+type jobImage struct {
+	id   string
+	name string
+	path string
+	err  error
+}
+
+// maxFilenameLength bounds the original file names kept as image metadata.
+const maxFilenameLength = 255
+
+// sanitizeFilename turns a client supplied file name into display metadata:
+// backslashes become slashes, control characters and invalid UTF-8 are
+// dropped and the result is cut to maxFilenameLength bytes.
+func sanitizeFilename(filename string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r == '\\':
+			return '/'
+		case unicode.IsControl(r), r == utf8.RuneError:
+			return -1
+		}
+		return r
+	}, filename)
+	name = strings.TrimSpace(name)
+	for len(name) > maxFilenameLength {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	if name == "" {
+		return "unnamed"
+	}
+	return name
+}
+
+// JobUpload receives a batch job's images while they are uploaded. The job is
+// queued as soon as its upload starts, so workers can process early images
+// before the last one arrives. A worker only takes the job while it has
+// images to process; it waits in the queue for the others.
+type JobUpload struct {
+	JobID string
+
+	ctx          context.Context
+	clientID     string
+	dir          string
+	maxFileSize  int64
+	noCache      bool
+	matchHistory bool
+	limits       archiveLimits
+	running      *runningJob
+
+	mu       sync.Mutex
+	images   []jobImage
+	complete bool
+	wake     func() // Tells the job queue that images or complete changed
+}
+
+// Add spools one image read from r into the job directory. Files that aren't
+// images or exceed the per-file limit are rejected with ErrUnsupportedUpload or
+// ErrFileTooLarge; once the job has ended Add returns ErrJobFinished.
+func (u *JobUpload) Add(filename string, r io.Reader) (int64, error) {
+	if u.ctx.Err() != nil {
+		return 0, ErrJobFinished
+	}
+
+	// Sniff the content before spooling anything to disk.
+	header := make([]byte, 512)
+	n, err := io.ReadFull(r, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return 0, err
+	}
+	if err := sniffImage(header[:n]); err != nil {
+		return 0, err
+	}
+
+	// Images are stored under generated IDs; the client's file name is only
+	// kept as metadata, so names can neither collide nor escape the directory.
+	image := u.newImage(filename)
+	file, err := os.Create(image.path)
+	if err != nil {
+		return 0, err
+	}
+	content := io.MultiReader(bytes.NewReader(header[:n]), r)
+	written, err := io.Copy(file, io.LimitReader(content, u.maxFileSize+1))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && written > u.maxFileSize {
+		err = ErrFileTooLarge
+	}
+	if err != nil {
+		os.Remove(image.path)
+		return 0, err
+	}
+
+	if err := u.append(image); err != nil {
+		return 0, err
+	}
+	return written, nil
+}
+
+// adopt adds an image already stored at path, such as a file of a completed
+// resumable upload, by linking it into the job directory. The file at path is
+// left in place.
+func (u *JobUpload) adopt(filename string, path string) error {
+	if u.ctx.Err() != nil {
+		return ErrJobFinished
+	}
+	image := u.newImage(filename)
+	if err := os.Link(path, image.path); err != nil {
+		return err
+	}
+	return u.append(image)
+}
+
+// addFailed records an image that couldn't be obtained, so it is reported as
+// failed with err among the job's results.
+func (u *JobUpload) addFailed(filename string, err error) error {
+	if u.ctx.Err() != nil {
+		return ErrJobFinished
+	}
+	image := u.newImage(filename)
+	image.path = ""
+	image.err = err
+	return u.append(image)
+}
+
+// newImage assigns an ID and storage path to an image of the upload.
+func (u *JobUpload) newImage(filename string) jobImage {
+	id := uuid.New().String()
+	return jobImage{id: id, name: sanitizeFilename(filename), path: filepath.Join(u.dir, id)}
+}
+
+// append makes a spooled image available to the workers.
+func (u *JobUpload) append(image jobImage) error {
+	u.mu.Lock()
+	if u.complete || u.ctx.Err() != nil {
+		u.mu.Unlock()
+		os.Remove(image.path)
+		return ErrJobFinished
+	}
+	u.images = append(u.images, image)
+	u.running.remaining.Add(1)
+	u.mu.Unlock()
+	u.wake()
+	return nil
+}
+
+// finish marks the upload complete; no further images are accepted.
+func (u *JobUpload) finish() {
+	u.mu.Lock()
+	complete := u.complete
+	u.complete = true
+	u.mu.Unlock()
+	if !complete {
+		u.wake()
+	}
+}
+
+// count returns the number of images uploaded so far and whether the upload is complete.
+func (u *JobUpload) count() (int, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return len(u.images), u.complete
+}
+
+// ready reports whether images from index i on have been uploaded, or the
+// upload is complete.
+func (u *JobUpload) ready(i int) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return i < len(u.images) || u.complete
+}
+
+// take returns up to limit of the images uploaded from index i on, without
+// waiting for more, and whether the upload is complete.
+func (u *JobUpload) take(i int, limit int) ([]jobImage, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if i >= len(u.images) {
+		return nil, u.complete
+	}
+	return append([]jobImage(nil), u.images[i:min(i+limit, len(u.images))]...), u.complete
+}
+
+// from returns the uploaded images from index i on.
+func (u *JobUpload) from(i int) []jobImage {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if i >= len(u.images) {
+		return nil
+	}
+	return append([]jobImage(nil), u.images[i:]...)
+}
+
+// sniffImage returns ErrUnsupportedUpload unless header, the first bytes of a
+// file, belongs to an allowed image type.
+func sniffImage(header []byte) error {
+	if len(header) == 0 {
+		return fmt.Errorf("%w: empty file", ErrUnsupportedUpload)
+	}
+	mimeType := http.DetectContentType(header)
+	if !strings.HasPrefix(mimeType, "image/") || !allowedMIMETypes[mimeType] {
+		return fmt.Errorf("%w: %s", ErrUnsupportedUpload, mimeType)
+	}
+	return nil
+}
+
+// sniffImageFile checks the stored file at path with sniffImage.
+func sniffImageFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	header := make([]byte, 512)
+	n, err := io.ReadFull(file, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return sniffImage(header[:n])
+}
+
+// StartJob creates a batch job and queues it before its images are uploaded.
+// Images are added through the returned JobUpload, which must be finished with
+// FinishUpload. StartJob refuses new jobs once shutdown has begun and returns
+// ErrQueueFull when the queue is at capacity.
+func (p *PredictionService) StartJob(jobID string, clientID string, options JobOptions) (*JobUpload, error) {
+	if p.draining.Load() {
+		return nil, ErrShuttingDown
+	}
+
+	jobDir := JobDir(jobID)
+	if err := os.MkdirAll(jobDir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create job directory: %v", err)
+	}
+
+	now := time.Now()
+	job := &Job{
+		JobID:       jobID,
+		ClientID:    clientID,
+		Priority:    options.Priority,
+		CallbackURL: options.CallbackURL,
+		Uploading:   true,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	job.SetStatus(JobStatusQueued, now)
+	if err := p.JobStore.Create(job); err != nil {
+		os.RemoveAll(jobDir)
+		return nil, fmt.Errorf("failed to create job: %v", err)
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	running := &runningJob{cancel: cancel, done: make(chan struct{})}
+	upload := &JobUpload{
+		JobID:        jobID,
+		ctx:          ctx,
+		dir:          jobDir,
+		maxFileSize:  p.Config.UploadMaxFileSize,
+		noCache:      options.NoCache,
+		clientID:     clientID,
+		matchHistory: options.MatchHistory,
+		limits:       archiveLimits{maxEntries: p.Config.ArchiveMaxEntries, maxSize: p.Config.ArchiveMaxSize},
+		running:      running,
+		wake:         p.queue.wake,
+	}
+	queued := &queuedJob{
+		ctx:        ctx,
+		jobID:      jobID,
+		clientID:   clientID,
+		priority:   priorityLevel(options.Priority),
+		enqueuedAt: now,
+		upload:     upload,
+		running:    running,
+	}
+
+	// Shutdown sets draining under the same lock, so it either waits for
+	// this job or the job is refused here.
+	p.running.Lock()
+	if p.draining.Load() {
+		p.running.Unlock()
+		cancel(ErrShuttingDown)
+		p.JobStore.Delete(jobID)
+		os.RemoveAll(jobDir)
+		return nil, ErrShuttingDown
+	}
+	if p.running.jobs == nil {
+		p.running.jobs = make(map[string]*runningJob)
+	}
+	p.running.jobs[jobID] = running
+	p.jobsWG.Add(1)
+	p.running.Unlock()
+
+	if err := p.queue.push(queued); err != nil {
+		p.running.Lock()
+		delete(p.running.jobs, jobID)
+		p.running.Unlock()
+		cancel(err)
+		p.jobsWG.Done()
+		p.JobStore.Delete(jobID)
+		os.RemoveAll(jobDir)
+		return nil, err
+	}
+	return upload, nil
+}
+
+// FinishUpload completes a job's upload and records its final image count.
+// When uploadErr is non-nil the upload failed: the job is cancelled and its
+// record deleted, since the client never learned its ID, and uploadErr is
+// returned. A job that was cancelled or stopped while its upload was still
+// streaming, failing the upload with ErrJobFinished, keeps its record, since
+// the client may have seen it in the job list.
+func (p *PredictionService) FinishUpload(upload *JobUpload, uploadErr error) (*Job, error) {
+	if uploadErr == nil {
+		upload.finish()
+		total, _ := upload.count()
+		return p.JobStore.Update(upload.JobID, func(job *Job) error {
+			job.Total = total
+			job.Uploading = false
+			return nil
+		})
+	}
+
+	// Cancel before completing the upload so the worker can't mistake the
+	// partial upload for a finished job.
+	if queued, ok := p.queue.remove(upload.JobID); ok {
+		p.abandonJob(queued, ErrUploadFailed)
+	} else {
+		upload.running.cancel(ErrUploadFailed)
+		<-upload.running.done
+	}
+	upload.finish()
+	if !errors.Is(context.Cause(upload.ctx), ErrUploadFailed) {
+		_, err := p.JobStore.Update(upload.JobID, func(job *Job) error {
+			job.Uploading = false
+			return nil
+		})
+		if err != nil {
+			p.Logger.Error("Failed to record upload of ended job", map[string]interface{}{"jobID": upload.JobID, "error": err.Error()}, err)
+		}
+		p.Logger.Info("Job ended during its upload", map[string]interface{}{"jobID": upload.JobID, "error": uploadErr.Error()})
+		return nil, uploadErr
+	}
+	if err := p.JobStore.Delete(upload.JobID); err != nil {
+		p.Logger.Error("Failed to delete job of failed upload", map[string]interface{}{"jobID": upload.JobID, "error": err.Error()}, err)
+	}
+	os.RemoveAll(upload.dir)
+	p.Logger.Info("Job upload failed", map[string]interface{}{"jobID": upload.JobID, "error": uploadErr.Error()})
+	return nil, uploadErr
+}
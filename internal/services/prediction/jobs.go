@@ -0,0 +1,149 @@
+package prediction
+
+import (
+	"maps"
+	"slices"
+	"time"
+)
+
+// JobFilter selects the jobs returned by ListJobs.
+type JobFilter struct {
+	ClientID string
+	Statuses []string
+	Offset   int
+	Limit    int
+}
+
+// JobSummary is a lightweight view of a job without per-image results.
+type JobSummary struct {
+	JobID          string         `json:"jobID"`
+	Status         string         `json:"status"`
+	Progress       int            `json:"progress"`
+	Total          int            `json:"total"`
+	Uploading      bool           `json:"uploading,omitempty"`
+	Priority       string         `json:"priority,omitempty"`
+	QueuePosition  int            `json:"queuePosition,omitempty"`
+	EstimatedStart *time.Time     `json:"estimatedStart,omitempty"`
+	Counts         map[string]int `json:"counts"`
+	Results        JobResults     `json:"results"` // Without duplicates, listed on the job itself
+	CreatedAt      time.Time      `json:"createdAt"`
+	FinishedAt     *time.Time     `json:"finishedAt,omitempty"`
+}
+
+// JobResults summarizes the outcome of a job's processed images.
+type JobResults struct {
+	Succeeded int            `json:"succeeded"`
+	Failed    int            `json:"failed"`
+	Cancelled int            `json:"cancelled,omitempty"`
+	Errors    map[string]int `json:"errors,omitempty"` // Failed images per error code
+	// Duplicates groups the images that near-duplicate an earlier one.
+	Duplicates []DuplicateGroup `json:"duplicates,omitempty"`
+}
+
+// DuplicateGroup lists the images near-duplicating ImageID, the first photo of
+// the item. JobID is set when that photo was part of an earlier job.
+type DuplicateGroup struct {
+	ImageID    string   `json:"imageID"`
+	JobID      string   `json:"jobID,omitempty"`
+	Duplicates []string `json:"duplicates"`
+}
+
+// summarizeResults counts successes and failures, grouping failures by error
+// code and near-duplicates by their first image.
+func (j *Job) summarizeResults() JobResults {
+	var results JobResults
+	groups := make(map[[2]string]int) // Original job and image ID to index in results.Duplicates
+	for _, prediction := range j.Predictions {
+		if prediction.DuplicateOf != "" {
+			original := [2]string{prediction.DuplicateJobID, prediction.DuplicateOf}
+			i, ok := groups[original]
+			if !ok {
+				i = len(results.Duplicates)
+				groups[original] = i
+				results.Duplicates = append(results.Duplicates, DuplicateGroup{ImageID: prediction.DuplicateOf, JobID: prediction.DuplicateJobID})
+			}
+			results.Duplicates[i].Duplicates = append(results.Duplicates[i].Duplicates, prediction.ImageID)
+		}
+		switch prediction.Status {
+		case "Completed":
+			results.Succeeded++
+		case "Failed":
+			results.Failed++
+			if results.Errors == nil {
+				results.Errors = make(map[string]int)
+			}
+			results.Errors[prediction.ErrorCode]++
+		case "Cancelled":
+			results.Cancelled++
+		}
+	}
+	return results
+}
+
+// Summary counts the job's images per status from its metadata. Images
+// without a result yet are counted as "Pending".
+func (j *Job) Summary() JobSummary {
+	counts := make(map[string]int, len(j.Counts)+1)
+	processed := 0
+	for status, count := range j.Counts {
+		counts[status] = count
+		processed += count
+	}
+	if pending := j.Total - processed; pending > 0 {
+		counts["Pending"] = pending
+	}
+	results := JobResults{
+		Succeeded: j.Counts["Completed"],
+		Failed:    j.Counts["Failed"],
+		Cancelled: j.Counts["Cancelled"],
+		Errors:    maps.Clone(j.ErrorCounts),
+	}
+	return JobSummary{
+		JobID:          j.JobID,
+		Status:         j.Status,
+		Progress:       j.Progress,
+		Total:          j.Total,
+		Uploading:      j.Uploading,
+		Priority:       j.Priority,
+		QueuePosition:  j.QueuePosition,
+		EstimatedStart: j.EstimatedStart,
+		Counts:         counts,
+		Results:        results,
+		CreatedAt:      j.CreatedAt,
+		FinishedAt:     j.FinishedAt,
+	}
+}
+
+// ListJobs returns the newest-first page of job summaries matching filter,
+// along with the total number of matching jobs.
+func (p *PredictionService) ListJobs(filter JobFilter) ([]JobSummary, int, error) {
+	jobs, err := p.JobStore.List()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var matched []*Job
+	for _, job := range jobs {
+		if job.ClientID != filter.ClientID {
+			continue
+		}
+		if len(filter.Statuses) > 0 && !slices.Contains(filter.Statuses, job.Status) {
+			continue
+		}
+		matched = append(matched, job)
+	}
+	slices.SortFunc(matched, func(a, b *Job) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+
+	p.setQueueEstimates(matched...)
+
+	total := len(matched)
+	start := min(filter.Offset, total)
+	end := min(start+filter.Limit, total)
+	summaries := make([]JobSummary, 0, end-start)
+	for _, job := range matched[start:end] {
+		summaries = append(summaries, job.Summary())
+	}
+	return summaries, total, nil
+}
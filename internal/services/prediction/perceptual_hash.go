@@ -0,0 +1,108 @@
+package prediction
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math/bits"
+	"slices"
+	"strconv"
+
+	"github.com/nfnt/resize"
+)
+
+// differenceHash computes a 64-bit perceptual hash (dHash) of img: the image
+// is shrunk to 9x8 grayscale pixels and each bit records whether a pixel is
+// brighter than its right neighbour. Photos of the same scene hash to values
+// a small Hamming distance apart, regardless of size or compression.
+func differenceHash(img image.Image) uint64 {
+	small := resize.Resize(9, 8, img, resize.Bilinear)
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if luminance(small.At(x, y)) > luminance(small.At(x+1, y)) {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+func luminance(c color.Color) uint8 {
+	return color.GrayModel.Convert(c).(color.Gray).Y
+}
+
+// formatHash renders a perceptual hash as 16 hex digits.
+func formatHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+// parseHash reads a hash rendered by formatHash.
+func parseHash(s string) (uint64, bool) {
+	hash, err := strconv.ParseUint(s, 16, 64)
+	return hash, err == nil
+}
+
+// hashedImage is an image known to the duplicate index.
+type hashedImage struct {
+	jobID   string
+	imageID string
+	hash    uint64
+}
+
+// duplicateIndex finds near-duplicates among the images seen so far: images
+// whose perceptual hashes differ in at most maxDistance bits.
+type duplicateIndex struct {
+	maxDistance int
+	images      []hashedImage
+}
+
+// match returns the first indexed image near-duplicating hash.
+func (d *duplicateIndex) match(hash uint64) (hashedImage, bool) {
+	for _, image := range d.images {
+		if bits.OnesCount64(image.hash^hash) <= d.maxDistance {
+			return image, true
+		}
+	}
+	return hashedImage{}, false
+}
+
+func (d *duplicateIndex) add(image hashedImage) {
+	d.images = append(d.images, image)
+}
+
+// newDuplicateIndex returns the index a job's images are matched against.
+// With history it already holds the images of the client's earlier jobs, so
+// photos resubmitted in a later audit are recognized too.
+func (p *PredictionService) newDuplicateIndex(clientID string, jobID string, history bool) *duplicateIndex {
+	index := &duplicateIndex{maxDistance: p.Config.DuplicateDistance}
+	if !history {
+		return index
+	}
+	jobs, err := p.JobStore.List()
+	if err != nil {
+		p.Logger.Error("Failed to load job history for duplicate detection", map[string]interface{}{"jobID": jobID, "error": err.Error()}, err)
+		return index
+	}
+	slices.SortFunc(jobs, func(a, b *Job) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	for _, job := range jobs {
+		if job.ClientID != clientID || job.JobID == jobID {
+			continue
+		}
+		// Listed jobs carry only their metadata.
+		job, err := p.JobStore.Get(job.JobID)
+		if err != nil {
+			continue
+		}
+		for _, prediction := range job.Predictions {
+			// Only first occurrences are indexed; their duplicates point to them.
+			if hash, ok := parseHash(prediction.PerceptualHash); ok && prediction.DuplicateOf == "" {
+				index.add(hashedImage{jobID: job.JobID, imageID: prediction.ImageID, hash: hash})
+			}
+		}
+	}
+	return index
+}
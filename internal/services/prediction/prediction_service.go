@@ -1,16 +1,21 @@
 package prediction
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"image"
 	"image/jpeg"
-	"mime/multipart"
+	"io"
 	"net/http"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/gorilla/websocket"
 	"github.com/tonespy/ecosort_be/config"
 	"github.com/tonespy/ecosort_be/pkg/logger"
 
@@ -21,10 +26,54 @@ import (
 type PredictionService struct {
 	Config       *config.Config
 	Logger       *logger.Logger
+	JobStore     JobStore
+	Webhooks     *WebhookNotifier
+	Events       *JobEventBus
+	WebSockets   *WebSocketHub
+	Cache        *ResultCache
+	Detector     ObjectDetector // Detection mode's detector; tiles the classifier when nil
+	Fetcher      *ImageFetcher
 	model        *tf.SavedModel
 	sessionMutex sync.Mutex
+	loaded       atomic.Bool // Whether model is set, readable without sessionMutex
+	ready        atomic.Bool
+	jobsWG       sync.WaitGroup
+	draining     atomic.Bool
+	queue        *jobQueue
+	avgImageTime atomic.Int64
+	janitorStop  chan struct{}
+	uploadLocks  sync.Map // Upload ID to *sync.Mutex, see lockUpload
+	running      struct {
+		sync.Mutex
+		jobs map[string]*runningJob
+	}
+}
+
+// runningJob tracks a queued or running job of this instance so it can be cancelled.
+// remaining counts the images the job still has to process.
+type runningJob struct {
+	cancel    context.CancelCauseFunc
+	done      chan struct{}
+	remaining atomic.Int64
 }
 
+// shutdownGracePeriod bounds flushing webhooks and closing websockets at
+// shutdown, which run after the job drain may have used up its deadline.
+const shutdownGracePeriod = 5 * time.Second
+
+// defaultImageDuration seeds the per-image processing estimate before any
+// image has been processed.
+const defaultImageDuration = 500 * time.Millisecond
+
+var (
+	// ErrShuttingDown is returned when a job is submitted after shutdown has begun.
+	ErrShuttingDown = errors.New("server is shutting down")
+	// ErrJobCancelled is the cancellation cause for jobs cancelled by a client.
+	ErrJobCancelled = errors.New("job cancelled")
+	// ErrJobFinished is returned when cancelling a job that already ended.
+	ErrJobFinished = errors.New("job already finished")
+)
+
 // Allowed MIME types for images and videos
 var allowedMIMETypes = map[string]bool{
 	"image/jpeg": true,
@@ -35,31 +84,22 @@ var allowedMIMETypes = map[string]bool{
 	"video/mpeg": true,
 }
 
-// jobProgressMap is an in-memory "database" for job progress.
-var jobProgressMap = struct {
-	sync.RWMutex
-	Data map[string]JobProgress
-}{
-	Data: make(map[string]JobProgress),
-}
-
-var (
-	// wsConnections stores active WebSocket connections keyed by job ID.
-	wsConnections = struct {
-		sync.RWMutex
-		Connections map[string]*websocket.Conn
-	}{Connections: make(map[string]*websocket.Conn)}
-
-	upgrader = websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool { return true },
-	}
-)
-
 type JobImagePrediction struct {
 	JobID      string         `json:"jobID"`
 	Prediction config.Classes `json:"prediction"`
-	ImageName  string         `json:"imageName"`
+	ImageID    string         `json:"imageID,omitempty"` // Stable ID of the image within its job
+	ImageName  string         `json:"imageName"`         // File name as uploaded
 	Status     string         `json:"status,omitempty"`
+	ErrorCode  string         `json:"errorCode,omitempty"` // e.g. "decode_failed", "inference_error"
+	Error      string         `json:"error,omitempty"`
+	Attempts   int            `json:"attempts,omitempty"` // Inference attempts, including retries
+	Cached     bool           `json:"cached,omitempty"`   // Answered from the result cache
+	// PerceptualHash is the image's dHash; DuplicateOf names the image it
+	// near-duplicates, in the job DuplicateJobID when that is another one.
+	PerceptualHash string   `json:"perceptualHash,omitempty"`
+	DuplicateOf    string   `json:"duplicateOf,omitempty"`
+	DuplicateJobID string   `json:"duplicateJobID,omitempty"`
+	QualityIssues  []string `json:"qualityIssues,omitempty"` // e.g. "too_blurry", "too_dark"
 }
 
 type JobProgress struct {
@@ -71,142 +111,610 @@ type JobProgress struct {
 // InitModel loads the TensorFlow model once and stores it for reuse.
 func (p *PredictionService) InitModel() error {
 	// Use the latest model version from configuration.
-	latestVersion := p.Config.ModelVersions[len(p.Config.ModelVersions)-1].Version
-	modelPath := filepath.Join(p.Config.RootDir, "tmp", latestVersion+".keras")
+	modelPath := filepath.Join(p.Config.RootDir, "tmp", p.modelVersion()+".keras")
 	model, err := tf.LoadSavedModel(modelPath, []string{"serve"}, nil)
 	if err != nil {
 		return fmt.Errorf("failed to load model: %v", err)
 	}
 	p.model = model
+	p.loaded.Store(true)
 	p.Logger.Info("Model loaded from "+modelPath, nil)
 	return nil
 }
 
-// getWebSocketConnection retrieves the WebSocket connection for a given jobID.
-func getWebSocketConnection(jobID string) (*websocket.Conn, bool) {
-	wsConnections.RLock()
-	defer wsConnections.RUnlock()
-	conn, ok := wsConnections.Connections[jobID]
-	return conn, ok
+// modelVersion returns the configured model version in use, the latest one.
+func (p *PredictionService) modelVersion() string {
+	if len(p.Config.ModelVersions) == 0 {
+		return ""
+	}
+	return p.Config.ModelVersions[len(p.Config.ModelVersions)-1].Version
+}
+
+// WarmUpModel runs a configurable number of inferences on a synthetic tensor so
+// the first real request doesn't pay the graph initialization cost. The service
+// only reports ready once every warm-up run has succeeded.
+func (p *PredictionService) WarmUpModel() error {
+	if p.model == nil {
+		return fmt.Errorf("model is not loaded")
+	}
+
+	tensorData := syntheticImageTensor()
+	for i := 0; i < p.Config.ModelWarmupRuns; i++ {
+		start := time.Now()
+		if _, err := p.predictFromImageTensor(tensorData); err != nil {
+			return fmt.Errorf("warm-up run %d failed: %v", i+1, err)
+		}
+		p.Logger.Debug("Model warm-up run completed", map[string]interface{}{
+			"run":      i + 1,
+			"duration": time.Since(start).String(),
+		})
+	}
+
+	p.ready.Store(true)
+	p.Logger.Info("Model warmed up and ready", map[string]interface{}{"runs": p.Config.ModelWarmupRuns})
+	return nil
+}
+
+// ModelLoaded reports whether InitModel has loaded the TensorFlow model.
+func (p *PredictionService) ModelLoaded() bool {
+	return p.loaded.Load()
+}
+
+// IsReady reports whether the model is loaded and has been warmed up.
+func (p *PredictionService) IsReady() bool {
+	return p.ready.Load()
+}
+
+// syntheticImageTensor returns a mid-grey image tensor matching the model input shape.
+func syntheticImageTensor() [][][]float32 {
+	tensorData := make([][][]float32, 256)
+	for y := range tensorData {
+		row := make([][]float32, 256)
+		for x := range row {
+			row[x] = []float32{0.5, 0.5, 0.5}
+		}
+		tensorData[y] = row
+	}
+	return tensorData
 }
 
-func (p *PredictionService) GetUpgrader() websocket.Upgrader {
-	return upgrader
+// GetJob returns the stored record for a batch job.
+func (p *PredictionService) GetJob(jobID string) (*Job, error) {
+	job, err := p.JobStore.Get(jobID)
+	if err != nil {
+		return nil, err
+	}
+	p.setQueueEstimates(job)
+	results := job.summarizeResults()
+	job.Results = &results
+	return job, nil
 }
 
-func (p *PredictionService) GetWsConnections() *struct {
-	sync.RWMutex
-	Connections map[string]*websocket.Conn
-} {
-	return &wsConnections
+// setQueueEstimates fills in the live queue position and estimated start
+// time of queued jobs. The estimate assumes the images of running jobs and of
+// jobs queued ahead are shared evenly across workers at the average
+// per-image processing time.
+func (p *PredictionService) setQueueEstimates(jobs ...*Job) {
+	if p.queue == nil {
+		return
+	}
+	slots := p.queue.slots()
+
+	var runningImages int64
+	p.running.Lock()
+	for _, running := range p.running.jobs {
+		runningImages += running.remaining.Load()
+	}
+	p.running.Unlock()
+
+	now := time.Now()
+	imageDuration := p.averageImageDuration()
+	for _, job := range jobs {
+		slot, ok := slots[job.JobID]
+		if job.Status != JobStatusQueued || !ok {
+			continue
+		}
+		backlog := runningImages + int64(slot.imagesAhead)
+		estimatedStart := now.Add(time.Duration(backlog) * imageDuration / time.Duration(p.Config.JobWorkers))
+		job.QueuePosition = slot.position
+		job.EstimatedStart = &estimatedStart
+	}
 }
 
-func (p *PredictionService) GetJobProgressMap() *struct {
-	sync.RWMutex
-	Data map[string]JobProgress
-} {
-	return &jobProgressMap
+// recordImageDuration folds a processed image's duration into the moving average.
+func (p *PredictionService) recordImageDuration(duration time.Duration) {
+	previous := time.Duration(p.avgImageTime.Load())
+	if previous == 0 {
+		p.avgImageTime.Store(int64(duration))
+		return
+	}
+	p.avgImageTime.Store(int64(previous*4/5 + duration/5))
 }
 
-// processPredictions simulates batched prediction processing.
-func (p *PredictionService) ProcessPredictions(jobID string, files []*multipart.FileHeader, jobDir string) {
+// averageImageDuration returns the moving average time to process one image.
+func (p *PredictionService) averageImageDuration() time.Duration {
+	if average := p.avgImageTime.Load(); average > 0 {
+		return time.Duration(average)
+	}
+	return defaultImageDuration
+}
+
+// GetClientJob returns a job only if it belongs to clientID, so callers can't
+// discover other clients' jobs.
+func (p *PredictionService) GetClientJob(jobID string, clientID string) (*Job, error) {
+	job, err := p.GetJob(jobID)
+	if err != nil {
+		return nil, err
+	}
+	if job.ClientID != clientID {
+		return nil, ErrJobNotFound
+	}
+	return job, nil
+}
+
+// IsShuttingDown reports whether the service has stopped accepting new jobs.
+func (p *PredictionService) IsShuttingDown() bool {
+	return p.draining.Load()
+}
+
+// StartJobWorkers creates the job queue and starts the configured number of
+// workers pulling jobs from it.
+func (p *PredictionService) StartJobWorkers() {
+	p.queue = newJobQueue(p.Config.JobQueueDepth, p.Config.JobPriorityAging)
+	for i := 0; i < p.Config.JobWorkers; i++ {
+		go p.runJobWorker()
+	}
+	p.Logger.Info("Job workers started", map[string]interface{}{
+		"workers":    p.Config.JobWorkers,
+		"queueDepth": p.Config.JobQueueDepth,
+		"aging":      p.Config.JobPriorityAging.String(),
+	})
+}
+
+// runJobWorker processes queued jobs until the queue is closed. Jobs still
+// uploading go back to the queue when their uploaded images are processed.
+func (p *PredictionService) runJobWorker() {
+	for {
+		queued, ok := p.queue.pop()
+		if !ok {
+			return
+		}
+		if p.processJob(queued) {
+			p.queue.requeue(queued)
+			continue
+		}
+		p.finishJob(queued)
+	}
+}
+
+// finishJob releases the bookkeeping held for a job that will not run any further.
+func (p *PredictionService) finishJob(queued *queuedJob) {
+	p.running.Lock()
+	delete(p.running.jobs, queued.jobID)
+	p.running.Unlock()
+	queued.running.cancel(nil)
+	close(queued.running.done)
+	p.jobsWG.Done()
+}
+
+// abandonJob records a job removed from the queue, before a worker picked it
+// up or while it waited for more images.
+func (p *PredictionService) abandonJob(queued *queuedJob, cause error) {
+	queued.running.cancel(cause)
+	total, _ := queued.upload.count()
+	p.interruptJob(queued.ctx, queued.jobID, queued.upload, (queued.processed*100)/max(total, 1), nil, queued.processed)
+	p.finishJob(queued)
+}
+
+// JobOptions are the client supplied settings of a batch job.
+type JobOptions struct {
+	// Priority is the scheduling class, see ParseJobPriority.
+	Priority string
+	// CallbackURL receives progress and completion webhooks when set.
+	CallbackURL string
+	// NoCache skips result cache lookups for the job's images.
+	NoCache bool
+	// MatchHistory also marks images near-duplicating ones from the client's
+	// earlier jobs, not just from the same job.
+	MatchHistory bool
+}
+
+// PredictOptions are the client supplied settings of a single prediction.
+type PredictOptions struct {
+	// NoCache skips the result cache lookup; the fresh result is still cached.
+	NoCache bool
+	// Mode is PredictModeClassify or PredictModeDetect, see ParsePredictMode.
+	Mode string
+}
+
+// CancelJob cancels a client's queued or running job and waits until its
+// remaining images have been marked cancelled.
+func (p *PredictionService) CancelJob(ctx context.Context, jobID string, clientID string) (*Job, error) {
+	job, err := p.GetClientJob(jobID, clientID)
+	if err != nil {
+		return nil, err
+	}
+	if job.IsTerminal() {
+		return job, ErrJobFinished
+	}
+
+	// Jobs still waiting in the queue are removed and recorded right away.
+	if queued, ok := p.queue.remove(jobID); ok {
+		p.abandonJob(queued, ErrJobCancelled)
+		return p.JobStore.Get(jobID)
+	}
+
+	p.running.Lock()
+	running, ok := p.running.jobs[jobID]
+	p.running.Unlock()
+	if !ok {
+		// Not processed by this instance; record the cancellation directly.
+		defer os.RemoveAll(JobDir(jobID))
+		_, err := p.JobStore.Update(jobID, func(job *Job) error {
+			if job.IsTerminal() {
+				return ErrJobFinished
+			}
+			job.SetStatus(JobStatusCancelled, time.Now())
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return p.JobStore.Get(jobID)
+	}
+
+	running.cancel(ErrJobCancelled)
+	select {
+	case <-running.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return p.JobStore.Get(jobID)
+}
+
+// markImageProcessed decrements the remaining image count of a running job.
+func (p *PredictionService) markImageProcessed(jobID string) {
+	p.running.Lock()
+	defer p.running.Unlock()
+	if running, ok := p.running.jobs[jobID]; ok {
+		running.remaining.Add(-1)
+	}
+}
+
+// cancelRunningJobs cancels every job processed by this instance with cause.
+func (p *PredictionService) cancelRunningJobs(cause error) {
+	p.running.Lock()
+	defer p.running.Unlock()
+	for _, running := range p.running.jobs {
+		running.cancel(cause)
+	}
+}
+
+// Shutdown stops accepting new jobs and waits for queued and running jobs until
+// ctx is done. At the deadline, queued jobs are dropped and running jobs are
+// stopped after their current image, both recorded as stopped. Pending
+// webhooks, including the stopped jobs' ones, are then flushed and remaining
+// websocket clients sent a going-away close frame, each within its own grace
+// period. Close releases the model and job store afterwards.
+func (p *PredictionService) Shutdown(ctx context.Context) error {
+	// StartJob registers jobs under the same lock, so none is added to jobsWG
+	// once the wait below may have begun.
+	p.running.Lock()
+	p.draining.Store(true)
+	p.running.Unlock()
+	p.ready.Store(false)
+
+	done := make(chan struct{})
+	go func() {
+		p.jobsWG.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+		p.Logger.Info("All queued and running jobs drained", nil)
+	case <-ctx.Done():
+		p.Logger.Info("Shutdown deadline reached, stopping queued and running jobs", nil)
+		for _, queued := range p.queue.removeAll() {
+			p.abandonJob(queued, ErrShuttingDown)
+		}
+		p.cancelRunningJobs(ErrShuttingDown)
+		<-done
+		err = ctx.Err()
+	}
+	p.queue.close()
+	p.stopJanitor()
+
+	if p.Webhooks != nil {
+		graceCtx, cancel := context.WithTimeout(context.Background(), shutdownGracePeriod)
+		if closeErr := p.Webhooks.Close(graceCtx); closeErr != nil {
+			p.Logger.Info("Pending webhook deliveries abandoned", map[string]interface{}{"error": closeErr.Error()})
+		}
+		cancel()
+	}
+
+	if p.WebSockets != nil {
+		graceCtx, cancel := context.WithTimeout(context.Background(), shutdownGracePeriod)
+		if closeErr := p.WebSockets.CloseAll(graceCtx); closeErr != nil {
+			p.Logger.Info("Websocket clients still open at shutdown", map[string]interface{}{"clients": p.WebSockets.Count()})
+		}
+		cancel()
+	}
+	return err
+}
+
+// Close closes the TensorFlow session and the job store. It is called last,
+// once Shutdown has returned and the HTTP server has stopped.
+func (p *PredictionService) Close() error {
+	var err error
+	p.sessionMutex.Lock()
+	defer p.sessionMutex.Unlock()
+	p.loaded.Store(false)
+	if p.model != nil {
+		if closeErr := p.model.Session.Close(); closeErr != nil {
+			p.Logger.Error("Failed to close TensorFlow session", map[string]interface{}{"error": closeErr.Error()}, closeErr)
+			err = errors.Join(err, closeErr)
+		}
+		p.model = nil
+	}
+
+	if closeErr := p.JobStore.Close(); closeErr != nil {
+		p.Logger.Error("Failed to close job store", map[string]interface{}{"error": closeErr.Error()}, closeErr)
+		err = errors.Join(err, closeErr)
+	}
+	return err
+}
+
+// interruptJob records a job whose context was cancelled and removes its files.
+// Predictions already made for the current batch are kept. Jobs cancelled by a
+// client get their images from index processed on marked "Cancelled".
+func (p *PredictionService) interruptJob(ctx context.Context, jobID string, upload *JobUpload, progress int, predictions []JobImagePrediction, processed int) {
+	status := JobStatusCancelled
+	if errors.Is(context.Cause(ctx), ErrShuttingDown) {
+		status = JobStatusStopped
+	}
+
+	if status == JobStatusCancelled {
+		for _, image := range upload.from(processed) {
+			predictions = append(predictions, JobImagePrediction{
+				JobID:     jobID,
+				ImageID:   image.id,
+				ImageName: image.name,
+				Status:    "Cancelled",
+			})
+		}
+	}
+
+	total, _ := upload.count()
+	job, err := p.JobStore.AddPredictions(jobID, predictions, func(job *Job) error {
+		job.Progress = progress
+		job.Total = total
+		job.SetStatus(status, time.Now())
+		return nil
+	})
+	if err == nil {
+		// The final webhook carries all of the job's results.
+		job, err = p.JobStore.Get(jobID)
+	}
+	if err != nil {
+		p.Logger.Error("Failed to record interrupted job", map[string]interface{}{"jobID": jobID, "error": err.Error()}, err)
+	}
+	os.RemoveAll(upload.dir)
+
+	if job != nil {
+		p.notifyWebhook(job, "job."+status, JobProgress{Progress: job.Progress, Status: job.Status, Predictions: job.Predictions})
+	}
+
+	if job != nil {
+		// Earlier results were already published with their batch.
+		update := JobProgress{Progress: job.Progress, Status: job.Status, Predictions: predictions}
+		p.publishJobEvent(jobID, status, map[string]any{"jobID": jobID, "message": "Job " + status, "update": update, "results": job.summarizeResults()})
+	}
+	p.Logger.Info("Job interrupted", map[string]interface{}{"jobID": jobID, "status": status, "progress": progress})
+}
+
+// RecoverJobs marks jobs left queued or running by a previous process as
+// stopped, since their in-memory state and uploads did not survive the restart.
+func (p *PredictionService) RecoverJobs() error {
+	jobs, err := p.JobStore.List()
+	if err != nil {
+		return fmt.Errorf("failed to list jobs: %v", err)
+	}
+
+	for _, job := range jobs {
+		if job.IsTerminal() {
+			continue
+		}
+		_, err := p.JobStore.Update(job.JobID, func(job *Job) error {
+			job.SetStatus(JobStatusStopped, time.Now())
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to stop job %s: %v", job.JobID, err)
+		}
+		os.RemoveAll(JobDir(job.JobID))
+		p.Logger.Info("Marked interrupted job as stopped", map[string]interface{}{"jobID": job.JobID})
+	}
+	return nil
+}
+
+// processJob classifies a job's uploaded images in batches, publishing each
+// batch's results. While the upload is still running the progress is relative
+// to the images received so far. When the uploaded images are processed
+// before the upload completes, processJob returns true so the job can wait in
+// the queue for more instead of holding the worker.
+func (p *PredictionService) processJob(queued *queuedJob) bool {
+	ctx, jobID, upload := queued.ctx, queued.jobID, queued.upload
 	batchSize := 10
-	total := len(files)
-	for i := 0; i < total; i += batchSize {
-		end := min(i+batchSize, total)
+	processed := queued.processed
+
+	if !queued.started {
+		queued.started = true
+		queued.duplicates = p.newDuplicateIndex(upload.clientID, jobID, upload.matchHistory)
+		_, err := p.JobStore.Update(jobID, func(job *Job) error {
+			if job.IsTerminal() {
+				return ErrJobFinished
+			}
+			job.SetStatus(JobStatusRunning, time.Now())
+			return nil
+		})
+		if err != nil {
+			p.Logger.Error("Failed to start job", map[string]interface{}{"jobID": jobID, "error": err.Error()}, err)
+			return false
+		}
+	}
+	duplicates := queued.duplicates
+
+	for {
+		// Take up to a batch of the images that have arrived.
+		batch, complete := upload.take(processed, batchSize)
+		if len(batch) == 0 {
+			if !complete && ctx.Err() == nil {
+				return true
+			}
+			break
+		}
 
 		var predictions []JobImagePrediction
-		for j := i; j < end; j++ {
-			predictionResult, err := p.PredictImage(filepath.Join(jobDir, getJpgFileName(files[j])))
-			statusInfo := "Completed"
-			if err != nil {
-				statusInfo = "Failed"
+		for j, image := range batch {
+			if ctx.Err() != nil {
+				total, _ := upload.count()
+				p.interruptJob(ctx, jobID, upload, ((processed+j)*100)/total, predictions, processed+j)
+				return false
 			}
+			imageStart := time.Now()
+			result, err := p.predictJobImage(ctx, image, upload.noCache)
+			p.recordImageDuration(time.Since(imageStart))
+			p.markImageProcessed(jobID)
 			resultInfo := config.Classes{}
-			if predictionResult != nil {
-				resultInfo = *predictionResult
+			if result.class != nil {
+				resultInfo = *result.class
 			}
 			prediction := JobImagePrediction{
 				JobID:      jobID,
 				Prediction: resultInfo,
-				ImageName:  getJpgFileName(files[j]),
-				Status:     statusInfo,
+				ImageID:    image.id,
+				ImageName:  image.name,
+				Status:     "Completed",
+				Attempts:   result.attempts,
+				Cached:     result.cached,
+				// Quality issues are warnings, or the failure's reasons in reject mode.
+				QualityIssues: result.issues,
+			}
+			if result.hashed {
+				prediction.PerceptualHash = formatHash(result.hash)
+				if original, ok := duplicates.match(result.hash); ok {
+					prediction.DuplicateOf = original.imageID
+					if original.jobID != jobID {
+						prediction.DuplicateJobID = original.jobID
+					}
+				} else {
+					duplicates.add(hashedImage{jobID: jobID, imageID: image.id, hash: result.hash})
+				}
+			}
+			if err != nil {
+				prediction.Status = "Failed"
+				prediction.ErrorCode = imageErrorCode(err)
+				prediction.Error = err.Error()
+				p.Logger.Info("Image prediction failed", map[string]interface{}{
+					"jobID":     jobID,
+					"image":     prediction.ImageName,
+					"errorCode": prediction.ErrorCode,
+					"attempts":  prediction.Attempts,
+				})
 			}
 			predictions = append(predictions, prediction)
 		}
 
+		total, _ := upload.count()
+		previousProgress := (processed * 100) / total
+		processed += len(batch)
+		queued.processed = processed
 		update := JobProgress{
-			Progress:    (end * 100) / total,
-			Status:      "running",
+			Progress:    (processed * 100) / total,
+			Status:      JobStatusRunning,
 			Predictions: predictions,
 		}
 
-		// Update the in-memory database.
-		jobProgressMap.Lock()
-		// If JobID exists, update the predictions, status and progress. Otherwise, create a new entry.
-		if _, ok := jobProgressMap.Data[jobID]; !ok {
-			jobProgressMap.Data[jobID] = update
-		} else {
-			previousPredictions := jobProgressMap.Data[jobID]
-			update.Predictions = append(previousPredictions.Predictions, update.Predictions...)
-			jobProgressMap.Data[jobID] = update
+		// Persist the batch results.
+		job, err := p.JobStore.AddPredictions(jobID, predictions, func(job *Job) error {
+			job.Progress = update.Progress
+			job.Total = max(job.Total, total)
+			return nil
+		})
+		if err != nil {
+			p.Logger.Error("Failed to update job progress", map[string]interface{}{"jobID": jobID, "error": err.Error()}, err)
+		} else if crossedMilestone(previousProgress, update.Progress) {
+			p.notifyWebhook(job, WebhookEventProgress, update)
 		}
-		jobProgressMap.Unlock()
 
-		// If a WebSocket connection exists, send the update.
-		if ws, ok := getWebSocketConnection(jobID); ok {
-			ws.WriteJSON(update)
+		p.publishJobEvent(jobID, JobEventProgress, update)
+
+		// Simulate processing delay.
+		select {
+		case <-ctx.Done():
+		case <-time.After(1 * time.Second):
 		}
+	}
 
-		time.Sleep(1 * time.Second) // Simulate processing delay.
+	// The upload was aborted or the job cancelled while waiting for images.
+	if total, complete := upload.count(); !complete || processed < total {
+		p.interruptJob(ctx, jobID, upload, (processed*100)/max(total, 1), nil, processed)
+		return false
 	}
 
 	// Final update: mark as completed.
-	finalUpdate := JobProgress{
-		Progress: 100,
-		Status:   "completed",
-	}
-	if _, ok := jobProgressMap.Data[jobID]; !ok {
-		jobProgressMap.Data[jobID] = finalUpdate
-	} else {
-		previousPredictions := jobProgressMap.Data[jobID]
-		finalUpdate.Predictions = previousPredictions.Predictions
-		jobProgressMap.Data[jobID] = finalUpdate
-	}
-	jobProgressMap.Lock()
-	jobProgressMap.Data[jobID] = finalUpdate
-	jobProgressMap.Unlock()
-	if ws, ok := getWebSocketConnection(jobID); ok {
-		result := map[string]any{"jobID": jobID, "message": "Job completed", "update": finalUpdate}
-		ws.WriteJSON(result)
-		ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "Job completed"))
-		ws.Close()
-		os.RemoveAll(filepath.Join("wsjobs", jobID))
-	}
-}
-
-// validateFile checks the file type and size
-func validateFile(file *multipart.FileHeader) error {
-	// Open the file to check its MIME type
-	src, err := file.Open()
+	_, err := p.JobStore.Update(jobID, func(job *Job) error {
+		job.Progress = 100
+		job.SetStatus(JobStatusCompleted, time.Now())
+		return nil
+	})
+	var job *Job
+	if err == nil {
+		// The final webhook carries all of the job's results.
+		job, err = p.JobStore.Get(jobID)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to open file: %v", err)
+		p.Logger.Error("Failed to complete job", map[string]interface{}{"jobID": jobID, "error": err.Error()}, err)
+		return false
+	}
+	finalUpdate := JobProgress{
+		Progress:    job.Progress,
+		Status:      job.Status,
+		Predictions: job.Predictions,
 	}
-	defer src.Close()
+	p.notifyWebhook(job, "job."+job.Status, finalUpdate)
+	// Every result was already published with its batch.
+	finalUpdate.Predictions = nil
+	result := map[string]any{"jobID": jobID, "message": "Job completed", "update": finalUpdate, "results": job.summarizeResults()}
+	p.publishJobEvent(jobID, job.Status, result)
+	os.RemoveAll(upload.dir)
+	return false
+}
 
-	// Check the file MIME type
-	buffer := make([]byte, 512) // Read the first 512 bytes for MIME detection
-	if _, err := src.Read(buffer); err != nil {
-		return fmt.Errorf("failed to read file: %v", err)
+// publishJobEvent records an update in the job's event log, from which it is
+// delivered to every SSE and websocket subscriber of the job.
+func (p *PredictionService) publishJobEvent(jobID string, eventType string, data any) {
+	if p.Events != nil {
+		p.Events.Publish(jobID, eventType, data)
 	}
-	mimeType := http.DetectContentType(buffer)
+}
+
+// SubscribeJobEvents returns the job's events after sequence `after` and a
+// subscription for later ones, see JobEventBus.Subscribe.
+func (p *PredictionService) SubscribeJobEvents(jobID string, after int64) ([]JobEvent, *EventSubscription, bool) {
+	return p.Events.Subscribe(jobID, after)
+}
 
-	// Log detected MIME type for debugging
-	fmt.Printf("Detected MIME type: %s\n", mimeType)
+// validateContent checks the MIME type sniffed from the first bytes of a file,
+// falling back to the extension of filename, and the file size.
+func validateContent(header []byte, filename string, size int64) error {
+	mimeType := http.DetectContentType(header)
 
 	// Fallback to file extension if MIME detection fails
 	if mimeType == "application/octet-stream" {
-		ext := filepath.Ext(getJpgFileName(file))
+		ext := filepath.Ext(filename)
 		switch ext {
 		case ".jpg", ".jpeg":
 			mimeType = "image/jpeg"
@@ -231,27 +739,35 @@ func validateFile(file *multipart.FileHeader) error {
 	}
 
 	// Check file size: max 50 MB
-	const maxFileSize = 50 << 20 // 50 MB
-	if file.Size > maxFileSize {
-		return fmt.Errorf("file is too large: %d bytes", file.Size)
+	if size > maxImageSize {
+		return fmt.Errorf("file is too large: %d bytes", size)
 	}
 
 	return nil
 }
 
-func preprocessImage(imagePath string) ([][][]float32, error) {
-	// Open image
-	file, err := os.Open(imagePath)
-	if err != nil {
-		return nil, err
+// decodeImage decodes a JPEG image, the only format the model is fed.
+func decodeImage(file io.ReadSeeker) (image.Image, error) {
+	// Only JPEG is decoded; report anything else as an unsupported format
+	// rather than a corrupt image.
+	header := make([]byte, 512)
+	n, _ := file.Read(header)
+	if mimeType := http.DetectContentType(header[:n]); mimeType != "image/jpeg" {
+		return nil, &ImageError{Code: ImageErrorUnsupportedFormat, Err: fmt.Errorf("unsupported image type: %s", mimeType)}
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, &ImageError{Code: ImageErrorDecodeFailed, Err: err}
 	}
-	defer file.Close()
 
 	img, err := jpeg.Decode(file)
 	if err != nil {
-		return nil, err
+		return nil, &ImageError{Code: ImageErrorDecodeFailed, Err: err}
 	}
+	return img, nil
+}
 
+// imageTensor converts a decoded image to the model's input tensor.
+func imageTensor(img image.Image) [][][]float32 {
 	// Resize to model input size (256x256)
 	resizedImg := resize.Resize(256, 256, img, resize.Lanczos3)
 
@@ -273,32 +789,7 @@ func preprocessImage(imagePath string) ([][][]float32, error) {
 		tensorData[y] = row
 	}
 
-	return tensorData, nil
-}
-
-func getJpgFileName(file *multipart.FileHeader) string {
-	fullFileName := file.Filename
-	if filepath.Ext(fullFileName) != ".jpg" {
-		fullFileName += ".jpg"
-	}
-	return fullFileName
-}
-
-func (p *PredictionService) ValidateAndGetTemp(file *multipart.FileHeader) (string, error) {
-	// Validate the file
-	if err := validateFile(file); err != nil {
-		return "", err
-	}
-
-	// Create tmp directory for processing file
-	tmpDir, err := os.MkdirTemp(p.Config.RootDir, "tmp")
-	if err != nil {
-		return "", fmt.Errorf("failed to create temp directory: %v", err)
-	}
-
-	filePath := fmt.Sprintf("%s/%s", tmpDir, getJpgFileName(file))
-
-	return filePath, nil
+	return tensorData
 }
 
 // getPredictedClass returns the index of the class with the highest probability
@@ -327,16 +818,49 @@ func filterClassName(input []config.Classes, predicate func(int) bool) []config.
 // predictFromImageTensor performs inference on preprocessed tensor data using the shared model.
 // It locks the session to ensure concurrent calls are serialized.
 func (p *PredictionService) predictFromImageTensor(tensorData [][][]float32) (*config.Classes, error) {
-	// Reshape tensor to batch format: [1, 256, 256, 3]
-	batchTensor := [][][][]float32{tensorData}
-	tensor, err := tf.NewTensor(batchTensor)
+	probabilities, err := p.classProbabilities(tensorData)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create tensor: %v", err)
+		return nil, err
 	}
+	return p.predictedClass(probabilities)
+}
+
+// predictedClass maps the most probable class index to its supported class.
+func (p *PredictionService) predictedClass(probabilities []float32) (*config.Classes, error) {
+	predictedClass := getPredictedClass(probabilities)
 
+	// Map the index to a class name using the supported classes.
+	filtered := filterClassName(p.Config.SupportedClasses, func(i int) bool {
+		return i == predictedClass
+	})
+	if len(filtered) == 0 {
+		return nil, fmt.Errorf("class not found")
+	}
+
+	return &filtered[0], nil
+}
+
+// classProbabilities runs the model on an image tensor and returns the
+// probability of each class, indexed like config.Classes.Index.
+func (p *PredictionService) classProbabilities(tensorData [][][]float32) ([]float32, error) {
 	// Lock the session for thread-safe access.
 	p.sessionMutex.Lock()
 	defer p.sessionMutex.Unlock()
+	return p.runModel(tensorData)
+}
+
+// runModel is classProbabilities for callers already holding sessionMutex.
+func (p *PredictionService) runModel(tensorData [][][]float32) ([]float32, error) {
+	if p.model == nil {
+		return nil, fmt.Errorf("model is not loaded")
+	}
+
+	// Reshape tensor to batch format: [1, 256, 256, 3]
+	batchTensor := [][][][]float32{tensorData}
+	tensor, err := tf.NewTensor(batchTensor)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tensor: %v", err)
+	}
 
 	result, err := p.model.Session.Run(
 		map[tf.Output]*tf.Tensor{
@@ -351,36 +875,97 @@ func (p *PredictionService) predictFromImageTensor(tensorData [][][]float32) (*c
 		return nil, fmt.Errorf("failed to run model: %v", err)
 	}
 
-	// Extract probabilities and determine the predicted class.
-	probabilities := result[0].Value().([][]float32)[0]
-	predictedClass := getPredictedClass(probabilities)
+	// Extract the probabilities of the single image in the batch.
+	return result[0].Value().([][]float32)[0], nil
+}
 
-	// Map the index to a class name using the supported classes.
-	filtered := filterClassName(p.Config.SupportedClasses, func(i int) bool {
-		return i == predictedClass
-	})
-	if len(filtered) == 0 {
-		return nil, fmt.Errorf("class not found")
-	}
+// maxImageSize is the largest image accepted for a single prediction.
+const maxImageSize = 50 << 20 // 50 MB
+
+// PredictResult is the outcome of a single-image prediction. Class is set in
+// classification mode, Detection in detection mode.
+type PredictResult struct {
+	Class     *config.Classes
+	Detection *DetectionResult
+	Cached    bool // Answered from the result cache
+	// Warnings are the quality issues found in the image, see checkQuality.
+	Warnings []string
+}
 
-	return &filtered[0], nil
+// PredictBytes classifies an image held in memory. filename is only used for
+// the content checks when the type can't be sniffed.
+func (p *PredictionService) PredictBytes(data []byte, filename string, options PredictOptions) (PredictResult, error) {
+	if err := validateContent(data[:min(len(data), 512)], filename, int64(len(data))); err != nil {
+		return PredictResult{}, &ImageError{Code: ImageErrorUnsupportedFormat, Err: err}
+	}
+	img, err := decodeImage(bytes.NewReader(data))
+	if err != nil {
+		return PredictResult{}, err
+	}
+	return p.predictDecoded(img, sha256.Sum256(data), options)
 }
 
-// PredictImage handles a single-image prediction using the shared model.
-// It validates and preprocesses the image, then calls predictFromImageTensor.
-func (p *PredictionService) PredictImage(filePath string) (*config.Classes, error) {
-	// Defer cleanup of temporary files.
-	// defer os.RemoveAll(filepath.Dir(filePath))
-	defer os.Remove(filePath)
+// PredictReader classifies an image read from r, such as a multipart stream.
+// Images up to the configured memory limit are decoded in memory; larger ones
+// are spooled to a temporary file that is removed afterwards. Images over
+// 50 MB are rejected with ErrFileTooLarge.
+func (p *PredictionService) PredictReader(r io.Reader, filename string, options PredictOptions) (PredictResult, error) {
+	data, err := io.ReadAll(io.LimitReader(r, p.Config.PredictMemoryLimit+1))
+	if err != nil {
+		return PredictResult{}, err
+	}
+	if int64(len(data)) <= p.Config.PredictMemoryLimit {
+		return p.PredictBytes(data, filename, options)
+	}
+
+	file, err := os.CreateTemp("", "ecosort-predict-*")
+	if err != nil {
+		return PredictResult{}, fmt.Errorf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
 
-	// Preprocess the image into a tensor.
-	tensorData, err := preprocessImage(filePath)
+	// Hash the image while spooling it.
+	hash := sha256.New()
+	content := io.TeeReader(io.MultiReader(bytes.NewReader(data), r), hash)
+	size, err := io.Copy(file, io.LimitReader(content, maxImageSize+1))
+	if err != nil {
+		return PredictResult{}, err
+	}
+	if size > maxImageSize {
+		return PredictResult{}, ErrFileTooLarge
+	}
+	if err := validateContent(data[:512], filename, size); err != nil {
+		return PredictResult{}, &ImageError{Code: ImageErrorUnsupportedFormat, Err: err}
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return PredictResult{}, err
+	}
+	img, err := decodeImage(file)
 	if err != nil {
-		return nil, fmt.Errorf("failed to preprocess image: %v", err)
+		return PredictResult{}, err
 	}
+	return p.predictDecoded(img, [sha256.Size]byte(hash.Sum(nil)), options)
+}
 
-	// Use the shared inference function.
-	return p.predictFromImageTensor(tensorData)
+// predictDecoded runs the quality gate on a decoded image, then classifies it
+// or detects its items, or answers from the result cache. sum is the SHA-256
+// of the image bytes.
+func (p *PredictionService) predictDecoded(img image.Image, sum [sha256.Size]byte, options PredictOptions) (PredictResult, error) {
+	var result PredictResult
+	warnings, err := p.checkQuality(img)
+	if err != nil {
+		return result, err
+	}
+	result.Warnings = warnings
+	if options.Mode == PredictModeDetect {
+		result.Detection, result.Cached, err = p.detectDecoded(img, sum, options)
+		return result, err
+	}
+	result.Class, result.Cached, err = p.cachedPredict(p.resultKey(sum, PredictModeClassify), options.NoCache, func() (*config.Classes, error) {
+		return p.predictWithTimeout(imageTensor(img))
+	})
+	return result, err
 }
 
 func (p *PredictionService) GetModelVersions() []config.ModelInfo {
@@ -0,0 +1,145 @@
+package prediction
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tonespy/ecosort_be/config"
+)
+
+// resultKey identifies a prediction: the SHA-256 of the image bytes, the
+// model version that classified them and the prediction mode.
+type resultKey struct {
+	sum   [sha256.Size]byte
+	model string
+	mode  string
+}
+
+// cachedResult holds a config.Classes or, in detection mode, a DetectionResult.
+type cachedResult struct {
+	key       resultKey
+	value     any
+	expiresAt time.Time
+}
+
+// CacheStats reports the result cache's size and effectiveness.
+type CacheStats struct {
+	Entries  int     `json:"entries"`
+	Capacity int     `json:"capacity"`
+	Hits     int64   `json:"hits"`
+	Misses   int64   `json:"misses"`
+	HitRatio float64 `json:"hitRatio"`
+}
+
+// ResultCache is a bounded LRU of predictions keyed by image content, so
+// resubmitted images are answered without running inference again. Entries
+// expire after the TTL. A nil cache never hits.
+type ResultCache struct {
+	capacity int
+	ttl      time.Duration
+	hits     atomic.Int64
+	misses   atomic.Int64
+
+	mu      sync.Mutex
+	entries map[resultKey]*list.Element
+	order   *list.List // Most recently used first
+}
+
+// NewResultCache returns a cache of up to capacity results, or nil when
+// capacity is 0 and caching is disabled.
+func NewResultCache(capacity int, ttl time.Duration) *ResultCache {
+	if capacity <= 0 {
+		return nil
+	}
+	return &ResultCache{
+		capacity: capacity,
+		ttl:      ttl,
+		entries:  make(map[resultKey]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// get returns the cached prediction for key and counts the hit or miss.
+func (c *ResultCache) get(key resultKey) (any, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if ok && time.Now().After(element.Value.(*cachedResult).expiresAt) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		ok = false
+	}
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	c.order.MoveToFront(element)
+	return element.Value.(*cachedResult).value, true
+}
+
+// put caches a prediction, evicting the least recently used one when full.
+// Cached values are shared between requests and must not be modified.
+func (c *ResultCache) put(key resultKey, value any) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result := &cachedResult{key: key, value: value, expiresAt: time.Now().Add(c.ttl)}
+	if element, ok := c.entries[key]; ok {
+		element.Value = result
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(result)
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedResult).key)
+	}
+}
+
+// Stats returns the cache's current size and hit counts.
+func (c *ResultCache) Stats() CacheStats {
+	if c == nil {
+		return CacheStats{}
+	}
+	c.mu.Lock()
+	entries := len(c.entries)
+	c.mu.Unlock()
+	stats := CacheStats{Entries: entries, Capacity: c.capacity, Hits: c.hits.Load(), Misses: c.misses.Load()}
+	if lookups := stats.Hits + stats.Misses; lookups > 0 {
+		stats.HitRatio = float64(stats.Hits) / float64(lookups)
+	}
+	return stats
+}
+
+// resultKey returns the cache key of an image with the given content hash,
+// predicted in mode.
+func (p *PredictionService) resultKey(sum [sha256.Size]byte, mode string) resultKey {
+	return resultKey{sum: sum, model: p.modelVersion(), mode: mode}
+}
+
+// cachedPredict answers from the result cache when it holds key, unless
+// noCache is set, and otherwise runs predict and caches a successful result.
+// It reports whether the prediction came from the cache.
+func (p *PredictionService) cachedPredict(key resultKey, noCache bool, predict func() (*config.Classes, error)) (*config.Classes, bool, error) {
+	if !noCache {
+		if value, ok := p.Cache.get(key); ok {
+			class := value.(config.Classes)
+			return &class, true, nil
+		}
+	}
+	class, err := predict()
+	if err == nil {
+		p.Cache.put(key, *class)
+	}
+	return class, false, err
+}
@@ -0,0 +1,285 @@
+package prediction
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// uploadsRootDir holds one directory per resumable upload, apart from the job
+// directories so a job's cleanup never touches an unfinalized upload.
+const uploadsRootDir = "wsuploads"
+
+// uploadManifestName is the file in an upload directory describing the session.
+const uploadManifestName = "upload.json"
+
+var (
+	// ErrUploadNotFound is returned for unknown or foreign upload sessions.
+	ErrUploadNotFound = errors.New("upload not found")
+	// ErrOffsetMismatch is returned when a chunk doesn't start at the file's current offset.
+	ErrOffsetMismatch = errors.New("upload offset mismatch")
+	// ErrChunkTooLarge is returned when a chunk extends past the file's declared size.
+	ErrChunkTooLarge = errors.New("chunk exceeds declared file size")
+	// ErrUploadIncomplete is returned when finalizing an upload with missing bytes.
+	ErrUploadIncomplete = errors.New("upload is incomplete")
+	// ErrInvalidUpload is returned when a new upload session is rejected.
+	ErrInvalidUpload = errors.New("invalid upload")
+)
+
+// UploadSession is a resumable batch upload. Each file is sent in chunks at
+// increasing offsets and the session is finalized into a job with the same ID
+// once every file is complete. Chunks are stored in the job directory, so an
+// interrupted upload continues where it stopped.
+type UploadSession struct {
+	UploadID    string       `json:"uploadID"`
+	ClientID    string       `json:"clientID,omitempty"`
+	Priority    string       `json:"priority,omitempty"`
+	CallbackURL string       `json:"callbackURL,omitempty"`
+	Files       []UploadFile `json:"files"`
+	CreatedAt   time.Time    `json:"createdAt"`
+	UpdatedAt   time.Time    `json:"updatedAt"`
+}
+
+// UploadFile is a file of an upload session. Offset is the number of bytes
+// received so far, derived from the stored chunks whenever the session is read.
+type UploadFile struct {
+	Index  int    `json:"index"`
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Offset int64  `json:"offset"`
+}
+
+// Complete reports whether every byte of the file has been received.
+func (f UploadFile) Complete() bool {
+	return f.Offset == f.Size
+}
+
+// lockUpload serializes changes to an upload session and returns the
+// session, or ErrUploadNotFound if the client has no such session.
+func (p *PredictionService) lockUpload(uploadID string, clientID string) (*UploadSession, func(), error) {
+	value, _ := p.uploadLocks.LoadOrStore(uploadID, &sync.Mutex{})
+	lock := value.(*sync.Mutex)
+	lock.Lock()
+	session, err := p.GetUploadSession(uploadID, clientID)
+	if err != nil {
+		if errors.Is(err, ErrUploadNotFound) {
+			p.uploadLocks.Delete(uploadID)
+		}
+		lock.Unlock()
+		return nil, nil, err
+	}
+	return session, lock.Unlock, nil
+}
+
+// uploadDir returns the directory of an upload session.
+func uploadDir(uploadID string) string {
+	return filepath.Join(uploadsRootDir, uploadID)
+}
+
+// partPath returns where the chunks of a session's file are stored.
+func partPath(uploadID string, index int) string {
+	return filepath.Join(uploadDir(uploadID), fmt.Sprintf("%d.part", index))
+}
+
+// CreateUploadSession starts a resumable upload of the given files, checking
+// them against the batch upload limits.
+func (p *PredictionService) CreateUploadSession(clientID string, options JobOptions, files []UploadFile) (*UploadSession, error) {
+	if p.draining.Load() {
+		return nil, ErrShuttingDown
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%w: no files", ErrInvalidUpload)
+	}
+	if len(files) > p.Config.UploadMaxFiles {
+		return nil, fmt.Errorf("%w: at most %d files per upload", ErrInvalidUpload, p.Config.UploadMaxFiles)
+	}
+	var total int64
+	for i, file := range files {
+		if file.Name == "" {
+			return nil, fmt.Errorf("%w: file %d has no name", ErrInvalidUpload, i)
+		}
+		if file.Size <= 0 || file.Size > p.Config.UploadMaxFileSize {
+			return nil, fmt.Errorf("%w: size of %s must be between 1 and %d bytes", ErrInvalidUpload, file.Name, p.Config.UploadMaxFileSize)
+		}
+		total += file.Size
+	}
+	if total > p.Config.UploadMaxSize {
+		return nil, fmt.Errorf("%w: upload exceeds %d bytes", ErrInvalidUpload, p.Config.UploadMaxSize)
+	}
+
+	now := time.Now()
+	session := &UploadSession{
+		UploadID:    uuid.New().String(),
+		ClientID:    clientID,
+		Priority:    options.Priority,
+		CallbackURL: options.CallbackURL,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	for i, file := range files {
+		session.Files = append(session.Files, UploadFile{Index: i, Name: sanitizeFilename(file.Name), Size: file.Size})
+	}
+
+	if err := os.MkdirAll(uploadDir(session.UploadID), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create upload directory: %v", err)
+	}
+	for i := range session.Files {
+		if err := os.WriteFile(partPath(session.UploadID, i), nil, 0644); err != nil {
+			os.RemoveAll(uploadDir(session.UploadID))
+			return nil, fmt.Errorf("failed to create upload file: %v", err)
+		}
+	}
+	if err := writeUploadManifest(session); err != nil {
+		os.RemoveAll(uploadDir(session.UploadID))
+		return nil, err
+	}
+	p.Logger.Info("Upload session created", map[string]interface{}{"uploadID": session.UploadID, "files": len(files), "bytes": total})
+	return session, nil
+}
+
+// GetUploadSession returns a client's upload session with the current offsets.
+func (p *PredictionService) GetUploadSession(uploadID string, clientID string) (*UploadSession, error) {
+	session, err := readUploadManifest(uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if session.ClientID != clientID {
+		return nil, ErrUploadNotFound
+	}
+	return session, nil
+}
+
+// WriteUploadChunk appends a chunk read from r to a file of an upload session.
+// offset must equal the bytes already received for the file. Whatever arrives
+// before r fails is kept, so the client resumes from the returned offset.
+func (p *PredictionService) WriteUploadChunk(uploadID string, clientID string, index int, offset int64, r io.Reader) (int64, error) {
+	session, unlock, err := p.lockUpload(uploadID, clientID)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	if index < 0 || index >= len(session.Files) {
+		return 0, ErrUploadNotFound
+	}
+	file := session.Files[index]
+	if offset != file.Offset {
+		return file.Offset, ErrOffsetMismatch
+	}
+
+	part, err := os.OpenFile(partPath(uploadID, index), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return file.Offset, fmt.Errorf("failed to open upload file: %v", err)
+	}
+	remaining := file.Size - file.Offset
+	written, copyErr := io.Copy(part, io.LimitReader(r, remaining+1))
+	if written > remaining {
+		// Drop everything past the declared size.
+		part.Truncate(file.Size)
+		written, copyErr = remaining, ErrChunkTooLarge
+	}
+	if closeErr := part.Close(); copyErr == nil {
+		copyErr = closeErr
+	}
+
+	session.UpdatedAt = time.Now()
+	if err := writeUploadManifest(session); err != nil {
+		return file.Offset + written, err
+	}
+	return file.Offset + written, copyErr
+}
+
+// FinalizeUpload turns a complete upload session into a batch job with the
+// same ID. Every file must be an image. The job links the session's files and
+// the session is only removed once all of them were added, so when finalizing
+// fails the session is kept for the client to retry, inspect or delete.
+func (p *PredictionService) FinalizeUpload(uploadID string, clientID string) (*Job, error) {
+	session, unlock, err := p.lockUpload(uploadID, clientID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	for _, file := range session.Files {
+		if !file.Complete() {
+			return nil, fmt.Errorf("%w: %s has %d of %d bytes", ErrUploadIncomplete, file.Name, file.Offset, file.Size)
+		}
+		if err := sniffImageFile(partPath(uploadID, file.Index)); err != nil {
+			return nil, fmt.Errorf("%s: %w", file.Name, err)
+		}
+	}
+
+	upload, err := p.StartJob(uploadID, clientID, JobOptions{Priority: session.Priority, CallbackURL: session.CallbackURL})
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range session.Files {
+		if err := upload.adopt(file.Name, partPath(uploadID, file.Index)); err != nil {
+			return p.FinishUpload(upload, err)
+		}
+	}
+	if err := os.RemoveAll(uploadDir(uploadID)); err != nil {
+		p.Logger.Error("Failed to remove finalized upload", map[string]interface{}{"uploadID": uploadID, "error": err.Error()}, err)
+	}
+	p.uploadLocks.Delete(uploadID)
+	return p.FinishUpload(upload, nil)
+}
+
+// DeleteUploadSession aborts an upload session and removes its chunks.
+func (p *PredictionService) DeleteUploadSession(uploadID string, clientID string) error {
+	_, unlock, err := p.lockUpload(uploadID, clientID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	p.uploadLocks.Delete(uploadID)
+	return os.RemoveAll(uploadDir(uploadID))
+}
+
+// readUploadManifest loads an upload session and derives each file's offset
+// from the size of its stored chunks.
+func readUploadManifest(uploadID string) (*UploadSession, error) {
+	if uploadID == "" || filepath.Base(uploadID) != uploadID {
+		return nil, ErrUploadNotFound
+	}
+	data, err := os.ReadFile(filepath.Join(uploadDir(uploadID), uploadManifestName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload: %v", err)
+	}
+	var session UploadSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("failed to decode upload: %v", err)
+	}
+	for i := range session.Files {
+		info, err := os.Stat(partPath(uploadID, i))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read upload file: %v", err)
+		}
+		session.Files[i].Offset = info.Size()
+	}
+	return &session, nil
+}
+
+// writeUploadManifest atomically replaces the stored upload session.
+func writeUploadManifest(session *UploadSession) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("failed to encode upload: %v", err)
+	}
+	path := filepath.Join(uploadDir(session.UploadID), uploadManifestName)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to write upload: %v", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to write upload: %v", err)
+	}
+	return nil
+}
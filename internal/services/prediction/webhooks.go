@@ -0,0 +1,263 @@
+package prediction
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tonespy/ecosort_be/config"
+	"github.com/tonespy/ecosort_be/pkg/logger"
+)
+
+// Webhook request headers. The signature is the hex encoded HMAC-SHA256 of
+// the request body keyed with the webhook secret, prefixed with "sha256=".
+const (
+	WebhookSignatureHeader = "X-EcoSort-Signature"
+	WebhookEventHeader     = "X-EcoSort-Event"
+	WebhookDeliveryHeader  = "X-EcoSort-Delivery"
+)
+
+// Webhook events. Terminal events are named after the job status, e.g. "job.completed".
+const (
+	WebhookEventProgress = "job.progress"
+)
+
+// webhookMilestone is the progress step, in percent, between progress callbacks.
+const webhookMilestone = 25
+
+// WebhookPayload is the body POSTed to a job's callback URL.
+type WebhookPayload struct {
+	Event string `json:"event"`
+	JobID string `json:"jobID"`
+	JobProgress
+	Results *JobResults `json:"results,omitempty"`
+}
+
+// WebhookDelivery records a single attempt to deliver a webhook.
+type WebhookDelivery struct {
+	DeliveryID string    `json:"deliveryID"`
+	Event      string    `json:"event"`
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Success    bool      `json:"success"`
+	Error      string    `json:"error,omitempty"`
+	At         time.Time `json:"at"`
+}
+
+// ErrWebhooksDisabled is returned for callback URLs while no WEBHOOK_SECRET
+// is configured to sign the callbacks with.
+var ErrWebhooksDisabled = errors.New("callback_url requires WEBHOOK_SECRET to be configured")
+
+// ValidateCallbackURL checks that a callback URL is an absolute http(s) URL
+// and that callbacks can be signed.
+func (p *PredictionService) ValidateCallbackURL(callbackURL string) error {
+	if p.Config.WebhookSecret == "" {
+		return ErrWebhooksDisabled
+	}
+	parsed, err := url.Parse(callbackURL)
+	if err != nil || parsed.Host == "" || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return fmt.Errorf("callback_url must be an absolute http or https URL")
+	}
+	return nil
+}
+
+// WebhookNotifier delivers job callbacks. Each job gets its own sender so
+// callbacks arrive in order, while slow receivers never block inference.
+// Like image downloads, callbacks only reach public or allowlisted addresses.
+type WebhookNotifier struct {
+	client      *http.Client
+	secret      []byte
+	maxAttempts int
+	backoff     time.Duration
+	store       JobStore
+	logger      *logger.Logger
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	senders struct {
+		sync.Mutex
+		jobs map[string]chan WebhookPayload
+	}
+}
+
+func NewWebhookNotifier(cfg *config.Config, store JobStore, logger *logger.Logger) *WebhookNotifier {
+	ctx, cancel := context.WithCancel(context.Background())
+	n := &WebhookNotifier{
+		client: &http.Client{
+			Transport: newAddressGuard(cfg.ImageURLAllowlist).transport(cfg.WebhookTimeout),
+			Timeout:   cfg.WebhookTimeout,
+		},
+		secret:      []byte(cfg.WebhookSecret),
+		maxAttempts: cfg.WebhookMaxAttempts,
+		backoff:     cfg.WebhookBackoff,
+		store:       store,
+		logger:      logger,
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+	n.senders.jobs = make(map[string]chan WebhookPayload)
+	return n
+}
+
+// Notify queues a callback for job. Callbacks for jobs without a callback URL
+// are ignored. A terminal event ends the job's sender once delivered.
+func (n *WebhookNotifier) Notify(job *Job, payload WebhookPayload) {
+	if job.CallbackURL == "" || len(n.secret) == 0 {
+		return
+	}
+
+	n.senders.Lock()
+	defer n.senders.Unlock()
+	sender, ok := n.senders.jobs[job.JobID]
+	if !ok {
+		sender = make(chan WebhookPayload, 16)
+		n.senders.jobs[job.JobID] = sender
+		n.wg.Add(1)
+		go n.run(job.CallbackURL, sender)
+	}
+	sender <- payload
+	if payload.Event != WebhookEventProgress {
+		close(sender)
+		delete(n.senders.jobs, job.JobID)
+	}
+}
+
+// Close waits for queued callbacks to be delivered until ctx is done, then
+// abandons any remaining retries.
+func (n *WebhookNotifier) Close(ctx context.Context) error {
+	n.senders.Lock()
+	for jobID, sender := range n.senders.jobs {
+		close(sender)
+		delete(n.senders.jobs, jobID)
+	}
+	n.senders.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		n.wg.Wait()
+		close(done)
+	}()
+	defer n.cancel()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		n.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (n *WebhookNotifier) run(callbackURL string, sender chan WebhookPayload) {
+	defer n.wg.Done()
+	for payload := range sender {
+		n.deliver(callbackURL, payload)
+	}
+}
+
+// deliver POSTs a payload, retrying failed attempts with exponential backoff
+// and logging every attempt on the job.
+func (n *WebhookNotifier) deliver(callbackURL string, payload WebhookPayload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		n.logger.Error("Failed to encode webhook payload", map[string]interface{}{"jobID": payload.JobID, "error": err.Error()}, err)
+		return
+	}
+	mac := hmac.New(sha256.New, n.secret)
+	mac.Write(body)
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	deliveryID := uuid.New().String()
+
+	backoff := n.backoff
+	for attempt := 1; attempt <= n.maxAttempts; attempt++ {
+		delivery := WebhookDelivery{
+			DeliveryID: deliveryID,
+			Event:      payload.Event,
+			Attempt:    attempt,
+			At:         time.Now(),
+		}
+		statusCode, err := n.post(callbackURL, body, signature, payload.Event, deliveryID)
+		delivery.StatusCode = statusCode
+		delivery.Success = err == nil
+		if err != nil {
+			delivery.Error = err.Error()
+		}
+		n.record(payload.JobID, delivery)
+		if delivery.Success {
+			return
+		}
+
+		if attempt == n.maxAttempts {
+			n.logger.Info("Webhook delivery failed", map[string]interface{}{
+				"jobID":    payload.JobID,
+				"event":    payload.Event,
+				"attempts": attempt,
+				"error":    delivery.Error,
+			})
+			return
+		}
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (n *WebhookNotifier) post(callbackURL string, body []byte, signature string, event string, deliveryID string) (int, error) {
+	req, err := http.NewRequestWithContext(n.ctx, http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookSignatureHeader, signature)
+	req.Header.Set(WebhookEventHeader, event)
+	req.Header.Set(WebhookDeliveryHeader, deliveryID)
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// record appends a delivery attempt to the job's delivery log.
+func (n *WebhookNotifier) record(jobID string, delivery WebhookDelivery) {
+	if err := n.store.AddDelivery(jobID, delivery); err != nil {
+		n.logger.Error("Failed to record webhook delivery", map[string]interface{}{"jobID": jobID, "error": err.Error()}, err)
+	}
+}
+
+// crossedMilestone reports whether progress moved past a webhook milestone.
+func crossedMilestone(previous int, current int) bool {
+	return current < 100 && current/webhookMilestone > previous/webhookMilestone
+}
+
+// notifyWebhook sends job's current state to its callback URL, if any.
+func (p *PredictionService) notifyWebhook(job *Job, event string, update JobProgress) {
+	if p.Webhooks == nil || job == nil {
+		return
+	}
+	payload := WebhookPayload{Event: event, JobID: job.JobID, JobProgress: update}
+	if event != WebhookEventProgress {
+		results := job.summarizeResults()
+		payload.Results = &results
+	}
+	p.Webhooks.Notify(job, payload)
+}
@@ -0,0 +1,109 @@
+package prediction
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tonespy/ecosort_be/config"
+	"github.com/tonespy/ecosort_be/pkg/logger"
+)
+
+// deliverWebhook sends a terminal callback for a job to callbackURL and
+// returns the job's recorded delivery attempts.
+func deliverWebhook(t *testing.T, allowlist []string, callbackURL string) []WebhookDelivery {
+	t.Helper()
+	cfg := &config.Config{
+		WebhookSecret:      "secret",
+		WebhookTimeout:     5 * time.Second,
+		WebhookMaxAttempts: 1,
+		WebhookBackoff:     time.Millisecond,
+		ImageURLAllowlist:  allowlist,
+	}
+	store := NewMemoryJobStore()
+	job := &Job{JobID: "job-1", CallbackURL: callbackURL, Status: JobStatusCompleted}
+	if err := store.Create(job); err != nil {
+		t.Fatal(err)
+	}
+
+	notifier := NewWebhookNotifier(cfg, store, logger.NewLogger())
+	notifier.Notify(job, WebhookPayload{Event: "job.completed", JobID: job.JobID, JobProgress: JobProgress{Progress: 100, Status: JobStatusCompleted}})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := notifier.Close(ctx); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	stored, err := store.Get(job.JobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stored.Deliveries
+}
+
+func TestWebhookDeliversSignedPayload(t *testing.T) {
+	var received atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write(body)
+		if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); r.Header.Get(WebhookSignatureHeader) != want {
+			t.Errorf("signature = %q, want %q", r.Header.Get(WebhookSignatureHeader), want)
+		}
+		if event := r.Header.Get(WebhookEventHeader); event != "job.completed" {
+			t.Errorf("event header = %q", event)
+		}
+		var payload WebhookPayload
+		if err := json.Unmarshal(body, &payload); err != nil || payload.JobID != "job-1" {
+			t.Errorf("payload = %s, %v", body, err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	deliveries := deliverWebhook(t, []string{"127.0.0.1"}, receiver.URL)
+	if received.Load() != 1 {
+		t.Fatalf("receiver got %d requests, want 1", received.Load())
+	}
+	if len(deliveries) != 1 || !deliveries[0].Success || deliveries[0].StatusCode != http.StatusNoContent {
+		t.Fatalf("deliveries = %+v", deliveries)
+	}
+}
+
+func TestWebhookRefusesInternalAddresses(t *testing.T) {
+	var received atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Add(1)
+	}))
+	defer receiver.Close()
+
+	deliveries := deliverWebhook(t, nil, receiver.URL)
+	if received.Load() != 0 {
+		t.Fatalf("receiver on loopback got %d requests, want none", received.Load())
+	}
+	if len(deliveries) != 1 || deliveries[0].Success || !strings.Contains(deliveries[0].Error, ErrBlockedAddress.Error()) {
+		t.Fatalf("deliveries = %+v", deliveries)
+	}
+}
+
+func TestCallbackURLRequiresWebhookSecret(t *testing.T) {
+	p := &PredictionService{Config: &config.Config{}}
+	if err := p.ValidateCallbackURL("https://example.com/hook"); !errors.Is(err, ErrWebhooksDisabled) {
+		t.Fatalf("ValidateCallbackURL without secret = %v, want %v", err, ErrWebhooksDisabled)
+	}
+	p.Config.WebhookSecret = "secret"
+	if err := p.ValidateCallbackURL("https://example.com/hook"); err != nil {
+		t.Fatalf("ValidateCallbackURL with secret: %v", err)
+	}
+}
@@ -0,0 +1,293 @@
+package prediction
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// wsWriteWait is the time allowed to write a message to a client.
+	wsWriteWait = 10 * time.Second
+	// wsPongWait is the time allowed between pongs before a client is considered gone.
+	wsPongWait = 60 * time.Second
+	// wsPingInterval must be shorter than wsPongWait.
+	wsPingInterval = 30 * time.Second
+	// wsMaxMessageSize limits client commands.
+	wsMaxMessageSize = 4096
+)
+
+// WebSocketHub tracks every websocket client so they can be closed together
+// on shutdown. Any number of clients may subscribe to the same job; each is
+// fed from the job's event log by its own writer goroutine.
+type WebSocketHub struct {
+	Upgrader websocket.Upgrader
+
+	mu      sync.Mutex
+	clients map[*wsClient]struct{}
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+func NewWebSocketHub() *WebSocketHub {
+	return &WebSocketHub{
+		Upgrader: websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
+		clients: make(map[*wsClient]struct{}),
+	}
+}
+
+// wsClient is a single websocket connection. Only writeLoop writes to conn.
+type wsClient struct {
+	conn     *websocket.Conn
+	jobID    string
+	send     chan any
+	shutdown chan struct{}
+	stopOnce sync.Once
+}
+
+// attach registers a client for conn. Once the hub is closed it refuses the
+// connection with a going-away close frame instead.
+func (h *WebSocketHub) attach(conn *websocket.Conn, jobID string) (*wsClient, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "Server shutting down"), time.Now().Add(wsWriteWait))
+		conn.Close()
+		return nil, false
+	}
+	client := &wsClient{
+		conn:     conn,
+		jobID:    jobID,
+		send:     make(chan any, 8),
+		shutdown: make(chan struct{}),
+	}
+	h.clients[client] = struct{}{}
+	h.wg.Add(1)
+	return client, true
+}
+
+func (h *WebSocketHub) unregister(client *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.clients, client)
+	h.wg.Done()
+}
+
+// CloseAll sends every client a going-away close frame, refuses new ones and
+// waits until their connections are done or ctx expires.
+func (h *WebSocketHub) CloseAll(ctx context.Context) error {
+	h.mu.Lock()
+	h.closed = true
+	for client := range h.clients {
+		client.stop()
+	}
+	h.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		h.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Count returns the number of connected clients.
+func (h *WebSocketHub) Count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.clients)
+}
+
+func (c *wsClient) stop() {
+	c.stopOnce.Do(func() { close(c.shutdown) })
+}
+
+// reply queues a message for the writer, giving up once the client stops.
+func (c *wsClient) reply(message any) {
+	select {
+	case c.send <- message:
+	case <-c.shutdown:
+	}
+}
+
+// ServeJobWebSocket streams a job's updates to conn until the job ends, the
+// client disconnects or the server shuts down. The client may send
+// {"type": "cancel"} to cancel the job.
+//
+// When from is negative the client first receives the job's current state and
+// then the payload of each update. Otherwise it receives every JobEvent after
+// sequence `from`, so a client that reconnects with the last sequence it saw
+// gets each per-image result exactly once.
+func (p *PredictionService) ServeJobWebSocket(conn *websocket.Conn, job *Job, clientID string, from int64) {
+	client, ok := p.WebSockets.attach(conn, job.JobID)
+	if !ok {
+		return
+	}
+	defer p.WebSockets.unregister(client)
+
+	// Subscribe before taking the snapshot so no update falls in between.
+	backlog, subscription, known := p.Events.Subscribe(job.JobID, max(from, 0))
+	if subscription != nil {
+		defer subscription.Unsubscribe()
+	}
+	if snapshot, err := p.GetJob(job.JobID); err == nil {
+		job = snapshot
+	}
+
+	var initial []any
+	finished := ""
+	if from < 0 {
+		initial = append(initial, job)
+		if job.IsTerminal() {
+			finished = job.Status
+		}
+	} else {
+		// Without an event log (e.g. after a restart) start from a snapshot of the job.
+		if !known && len(backlog) == 0 {
+			initial = append(initial, JobEvent{JobID: job.JobID, Type: JobEventSnapshot, Data: job})
+			if job.IsTerminal() {
+				finished = job.Status
+			}
+		}
+		for _, event := range backlog {
+			initial = append(initial, event)
+			if event.Terminal() {
+				finished = event.Type
+				break
+			}
+		}
+	}
+	if finished == "" && subscription == nil {
+		finished = job.Status
+	}
+
+	var events <-chan JobEvent
+	if finished == "" {
+		events = subscription.C
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		client.writeLoop(initial, events, from >= 0, finished)
+	}()
+	p.readCommands(client, clientID)
+	client.stop()
+	<-done
+}
+
+// writeLoop is the only writer of the connection. It sends the initial
+// messages, then forwards job events (whole, or only their payload) and direct
+// replies, pings the client and closes the connection when the job ends, the
+// client falls behind or the hub shuts down. events may be nil for clients
+// that only receive direct replies.
+func (c *wsClient) writeLoop(initial []any, events <-chan JobEvent, envelope bool, finished string) {
+	defer c.stop()
+	defer c.conn.Close()
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+
+	for _, message := range initial {
+		if c.writeJSON(message) != nil {
+			return
+		}
+	}
+	if finished != "" {
+		c.writeClose(websocket.CloseNormalClosure, "Job "+finished)
+		return
+	}
+
+	for {
+		select {
+		case message := <-c.send:
+			if c.writeJSON(message) != nil {
+				return
+			}
+		case event, ok := <-events:
+			if !ok {
+				c.writeClose(websocket.CloseTryAgainLater, "Subscriber too slow")
+				return
+			}
+			var message any = event.Data
+			if envelope {
+				message = event
+			}
+			if c.writeJSON(message) != nil {
+				return
+			}
+			if event.Terminal() {
+				c.writeClose(websocket.CloseNormalClosure, "Job "+event.Type)
+				return
+			}
+		case <-ticker.C:
+			if c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)) != nil {
+				return
+			}
+		case <-c.shutdown:
+			c.writeClose(websocket.CloseGoingAway, "Server shutting down")
+			return
+		}
+	}
+}
+
+func (c *wsClient) writeJSON(message any) error {
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	return c.conn.WriteJSON(message)
+}
+
+func (c *wsClient) writeClose(code int, text string) {
+	c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(wsWriteWait))
+}
+
+// readCommands reads client messages until the connection fails, keeping the
+// read deadline alive with pongs and handling commands.
+func (p *PredictionService) readCommands(client *wsClient, clientID string) {
+	conn := client.conn
+	keepReading(conn, wsMaxMessageSize)
+
+	for {
+		messageType, message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if messageType != websocket.TextMessage {
+			continue
+		}
+		var command struct {
+			Type string `json:"type"`
+		}
+		if json.Unmarshal(message, &command) != nil || command.Type != "cancel" {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		_, err = p.CancelJob(ctx, client.jobID, clientID)
+		cancel()
+		if err != nil {
+			select {
+			case client.send <- map[string]any{"jobID": client.jobID, "error": err.Error()}:
+			default:
+			}
+		}
+	}
+}
+
+// keepReading limits message size and keeps the read deadline alive with pongs.
+func keepReading(conn *websocket.Conn, limit int64) {
+	conn.SetReadLimit(limit)
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+}
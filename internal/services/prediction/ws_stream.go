@@ -0,0 +1,184 @@
+package prediction
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/tonespy/ecosort_be/config"
+)
+
+// Message types of the prediction stream. A client announces each image with
+// an "image" text message and sends its bytes in the following binary frame;
+// the server answers with a "prediction" or "error" message carrying the same ID.
+const (
+	StreamMessageReady      = "ready"
+	StreamMessageImage      = "image"
+	StreamMessagePrediction = "prediction"
+	StreamMessageError      = "error"
+)
+
+// Error codes of the prediction stream, reported alongside the image error codes.
+const (
+	StreamErrorInvalidMessage  = "invalid_message"
+	StreamErrorMissingEnvelope = "missing_envelope"
+	StreamErrorBusy            = "busy"
+)
+
+const (
+	// streamMaxImageBytes limits the size of a single streamed image.
+	streamMaxImageBytes = 10 << 20
+	// streamQueueDepth is how many images a client may have waiting for
+	// inference before further images are rejected as busy.
+	streamQueueDepth = 4
+)
+
+// StreamMessage is a message of the prediction stream, in either direction.
+type StreamMessage struct {
+	Type          string          `json:"type"`
+	ID            string          `json:"id,omitempty"` // Client-chosen correlation ID, echoed in replies
+	Filename      string          `json:"filename,omitempty"`
+	Prediction    *config.Classes `json:"prediction,omitempty"`
+	Attempts      int             `json:"attempts,omitempty"`
+	Cached        bool            `json:"cached,omitempty"`
+	Warnings      []string        `json:"warnings,omitempty"` // Quality issues; the reasons of a poor_quality error
+	DurationMs    int64           `json:"durationMs,omitempty"`
+	ErrorCode     string          `json:"errorCode,omitempty"`
+	Error         string          `json:"error,omitempty"`
+	MaxImageBytes int             `json:"maxImageBytes,omitempty"` // Sent with "ready"
+}
+
+// streamImage is an announced image together with its bytes.
+type streamImage struct {
+	envelope StreamMessage
+	data     []byte
+}
+
+// ServePredictionStream classifies the images a client streams over conn and
+// sends each result back on the same connection, until the client disconnects
+// or the server shuts down. Images are classified one at a time in the order
+// they arrive.
+func (p *PredictionService) ServePredictionStream(conn *websocket.Conn, clientID string, options PredictOptions) {
+	client, ok := p.WebSockets.attach(conn, "")
+	if !ok {
+		return
+	}
+	defer p.WebSockets.unregister(client)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	images := make(chan streamImage, streamQueueDepth)
+	workerDone := make(chan struct{})
+	processed := 0
+	go func() {
+		defer close(workerDone)
+		for image := range images {
+			if ctx.Err() != nil {
+				continue
+			}
+			client.reply(p.predictStreamImage(ctx, image, options))
+			processed++
+		}
+	}()
+
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		ready := StreamMessage{Type: StreamMessageReady, MaxImageBytes: streamMaxImageBytes}
+		client.writeLoop([]any{ready}, nil, false, "")
+	}()
+
+	p.Logger.Info("Prediction stream opened", map[string]interface{}{"clientID": clientID})
+	readStream(client, images)
+	cancel()
+	close(images)
+	client.stop()
+	<-workerDone
+	<-writerDone
+	p.Logger.Info("Prediction stream closed", map[string]interface{}{"clientID": clientID, "images": processed})
+}
+
+// readStream reads envelopes and image frames until the connection fails,
+// queueing each complete image for inference.
+func readStream(client *wsClient, images chan<- streamImage) {
+	keepReading(client.conn, streamMaxImageBytes)
+
+	var pending *StreamMessage
+	for {
+		messageType, message, err := client.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		switch messageType {
+		case websocket.TextMessage:
+			var envelope StreamMessage
+			if json.Unmarshal(message, &envelope) != nil || envelope.Type != StreamMessageImage {
+				client.reply(StreamMessage{
+					Type:      StreamMessageError,
+					ID:        envelope.ID,
+					ErrorCode: StreamErrorInvalidMessage,
+					Error:     `expected {"type": "image"} envelope`,
+				})
+				continue
+			}
+			pending = &envelope
+
+		case websocket.BinaryMessage:
+			if pending == nil {
+				client.reply(StreamMessage{
+					Type:      StreamMessageError,
+					ErrorCode: StreamErrorMissingEnvelope,
+					Error:     "binary frame without an image envelope",
+				})
+				continue
+			}
+			select {
+			case images <- streamImage{envelope: *pending, data: message}:
+			default:
+				// Drop the frame rather than fall behind a live feed.
+				client.reply(StreamMessage{
+					Type:      StreamMessageError,
+					ID:        pending.ID,
+					Filename:  pending.Filename,
+					ErrorCode: StreamErrorBusy,
+					Error:     "too many images waiting for inference",
+				})
+			}
+			pending = nil
+		}
+	}
+}
+
+// predictStreamImage classifies a streamed image and builds the reply for it.
+func (p *PredictionService) predictStreamImage(ctx context.Context, image streamImage, options PredictOptions) StreamMessage {
+	start := time.Now()
+	reply := StreamMessage{
+		Type:     StreamMessagePrediction,
+		ID:       image.envelope.ID,
+		Filename: image.envelope.Filename,
+	}
+
+	img, err := decodeImage(bytes.NewReader(image.data))
+	if err == nil {
+		reply.Warnings, err = p.checkQuality(img)
+	}
+	if err == nil {
+		reply.Prediction, reply.Cached, err = p.cachedPredict(p.resultKey(sha256.Sum256(image.data), PredictModeClassify), options.NoCache, func() (*config.Classes, error) {
+			var class *config.Classes
+			var err error
+			class, reply.Attempts, err = p.predictWithRetry(ctx, imageTensor(img), image.envelope.Filename)
+			return class, err
+		})
+	}
+	reply.DurationMs = time.Since(start).Milliseconds()
+	if err != nil {
+		reply.Type = StreamMessageError
+		reply.Prediction = nil
+		reply.ErrorCode = imageErrorCode(err)
+		reply.Error = err.Error()
+	}
+	return reply
+}